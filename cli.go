@@ -4,31 +4,482 @@
 package main
 
 import (
-	"io/ioutil"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 	golsp "github.com/ajaymt/golsp/core"
-	// "fmt"
+	_ "github.com/ajaymt/golsp/stdlib"
 )
 
-func main() {
-	filename := "-"
-	dirname := "."
-	file := os.Stdin
-	var args []string
+// exit statuses
+const (
+	EXIT_OK = 0
+	// the program failed (its result is an error)
+	EXIT_FAILURE = 1
+	// the command line is invalid, including when the program file that it names
+	// cannot be read
+	EXIT_USAGE = 2
+	// the program did not finish before its timeout (see 'options')
+	EXIT_TIMEOUT = 124
+)
+
+// command: a subcommand, i.e 'golsp run'
+type command struct {
+	usage string
+	description string
+	run func(args []string) int
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"run": {"run [flags] file|- [args...]", "run a file, or stdin", runCommand},
+		"eval": {"eval [flags] -e expr [args...]", "evaluate an expression and print its result", evalCommand},
+		"repl": {"repl [flags]", "start the REPL", replCommand},
+		"test": {"test [flags] [files or dirs...]",
+			"run the *_test.golsp files in the current directory, or in the given files and directories",
+			testCommand},
+		"snapshot": {"snapshot [-update] [files or dirs...]",
+			"compare the output of programs with their .golden files", snapshotCommand},
+		"check": {"check file|-...", "check the syntax of files without running them", checkCommand},
+		"fmt": {"fmt [-w | -check] [files or dirs...]",
+			"print programs in the canonical layout, or stdin if no files are given", fmtCommand},
+		"lint": {"lint [-disable rules] [files or dirs...|-]",
+			"report likely mistakes in programs without running them", lintCommand},
+		"ast": {"ast [-e expr | file|-]", "print the syntax tree of a program", astCommand},
+		"tokens": {"tokens [-e expr | file|-]", "print the tokens of a program", tokensCommand},
+		"resolve": {"resolve [flags] name [file]",
+			"print how 'require' resolves a module name from a file", resolveCommand},
+		"mod": {"mod vendor", "copy the dependencies in golsp.mod into golsp_modules", modCommand},
+		"help": {"help [command]", "print help for a command", helpCommand},
+	}
+}
+
+// fail: print an error message
+// this function returns EXIT_FAILURE
+func fail(format string, args ...interface{}) int {
+	fmt.Fprintf(os.Stderr, "golsp: " + format + "\n", args...)
+	return EXIT_FAILURE
+}
+
+// newFlagSet: create the flag set of a command, which prints the command's usage
+// on '-h' and exits with EXIT_USAGE when the flags are invalid
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet("golsp " + name, flag.ExitOnError)
+	flags.Usage = func () {
+		cmd := commands[name]
+		fmt.Fprintf(flags.Output(), "usage: golsp %s\n\n%s\n", cmd.usage, cmd.description)
+		if hasFlags(flags) {
+			fmt.Fprintf(flags.Output(), "\nflags:\n")
+			flags.PrintDefaults()
+		}
+	}
+
+	return flags
+}
+
+func hasFlags(flags *flag.FlagSet) bool {
+	count := 0
+	flags.VisitAll(func (*flag.Flag) { count++ })
+	return count > 0
+}
+
+// options: the flags that configure an interpreter
+type options struct {
+	stdlibPath string
+	modules string
+	maxDepth int
+	timeout time.Duration
+	trace bool
+}
+
+func (opts *options) register(flags *flag.FlagSet) {
+	flags.StringVar(&opts.stdlibPath, "path", "",
+		"load 'stdlib/' modules from `dir` instead of the embedded standard library (overrides $GOLSPPATH)")
+	flags.StringVar(&opts.modules, "modules", "",
+		"colon-separated `dirs` to search for bare module names before $GOLSPMODULES")
+	flags.IntVar(&opts.maxDepth, "max-depth", 0,
+		"make calls to functions nested more than `n` deep produce an error (0 for no limit)")
+	flags.DurationVar(&opts.timeout, "timeout", 0,
+		"exit with status 124 if the program runs for longer than this")
+	flags.BoolVar(&opts.trace, "trace", false, "print every function call to stderr")
+}
+
+// interpreter: create an interpreter that is configured by the options. The
+// timeout, if there is one, starts immediately
+func (opts *options) interpreter() *golsp.Interpreter {
+	interp := golsp.NewInterpreter()
+	if opts.stdlibPath != "" { interp.StdlibPath = opts.stdlibPath }
+	if opts.modules != "" {
+		interp.SearchPath = append(filepath.SplitList(opts.modules), interp.SearchPath...)
+	}
+	interp.MaxDepth = opts.maxDepth
+	if opts.trace { interp.Trace = os.Stderr }
+	if opts.timeout > 0 {
+		time.AfterFunc(opts.timeout, func () {
+			fmt.Fprintf(os.Stderr, "golsp: timed out after %v\n", opts.timeout)
+			interp.Exit(EXIT_TIMEOUT)
+		})
+	}
+
+	return interp
+}
+
+// readFailure: print an error from reading the program that the command line names.
+// A program that cannot be read is an invalid command line, like a missing argument
+// this function returns EXIT_USAGE
+func readFailure(err error) int {
+	fmt.Fprintf(os.Stderr, "golsp: %v\n", err)
+	return EXIT_USAGE
+}
+
+// readProgram: read a program from a file, or from stdin if the filename is '-'
+// this function returns the program's directory, its absolute filename ('-' for
+// stdin), the program and an optional error
+func readProgram(filename string) (string, string, string, error) {
+	dirname, _ := os.Getwd()
+	if filename == "-" {
+		input, err := io.ReadAll(os.Stdin)
+		return dirname, filename, string(input), err
+	}
+
+	filename, _ = filepath.Abs(filename)
+	input, err := os.ReadFile(filename)
+
+	return filepath.Dir(filename), filename, string(input), err
+}
+
+// readSource: read the program that a command operates on -- the '-e' expression
+// if there is one, otherwise the file named by the first argument
+// this function returns the program's directory, its filename ('-e' for an
+// expression), the program and an optional error
+func readSource(expr string, args []string) (string, string, string, error) {
+	if expr != "" {
+		dirname, _ := os.Getwd()
+		return dirname, "-e", expr, nil
+	}
+	if len(args) != 1 { return "", "", "", fmt.Errorf("expected -e expr or one file") }
+
+	return readProgram(args[0])
+}
+
+// syntaxError: print a syntax error with the name of the file that contains it
+// this function returns EXIT_FAILURE
+func syntaxError(filename string, err error) int {
+	if syntax, ok := err.(golsp.SyntaxError); ok {
+		return fail("%s:%d: %s", filename, syntax.Line, syntax.Message)
+	}
+	return fail("%s: %v", filename, err)
+}
+
+// findFiles: find the files with a suffix in a list of files and directories.
+// Files are always included, and directories are searched recursively, except for
+// hidden directories and golsp_modules
+// `paths`: the files and directories
+// `suffix`: the suffix, i.e '.golsp' or '_test.golsp'
+// this function returns the files and an optional error
+func findFiles(paths []string, suffix string) ([]string, error) {
+	files := []string{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil { return nil, err }
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		err = filepath.WalkDir(path, func (path string, entry fs.DirEntry, err error) error {
+			if err != nil { return err }
+			if entry.IsDir() && path != "." && path != ".." &&
+				(strings.HasPrefix(entry.Name(), ".") || entry.Name() == golsp.MODULES_DIR) {
+				return filepath.SkipDir
+			}
+			if !entry.IsDir() && strings.HasSuffix(entry.Name(), suffix) {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil { return nil, err }
+	}
+
+	return files, nil
+}
+
+// runProgram: check a program's syntax and then run it
+// `print`: whether to print the program's result
+// this function returns the exit status -- EXIT_FAILURE if the program has a syntax
+// error or its result is an error
+func runProgram(interp *golsp.Interpreter, dirname string, filename string,
+	args []string, program string, print bool) int {
+	if err := golsp.CheckSyntax(golsp.Tokenize(program)); err != nil {
+		return syntaxError(filename, err)
+	}
+
+	interp.HandleSignals = true
+	result := interp.Run(dirname, filename, args, program)
+	if result.Type == golsp.ObjectTypeError { return fail("%s", golsp.Format(result)) }
+	if print && !(result.Type == golsp.ObjectTypeLiteral && result.Value.Head == golsp.UNDEFINED) {
+		fmt.Println(golsp.Format(result))
+	}
+
+	return EXIT_OK
+}
+
+func runCommand(args []string) int {
+	flags := newFlagSet("run")
+	opts := options{}
+	opts.register(flags)
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return EXIT_USAGE
+	}
+
+	dirname, filename, program, err := readProgram(flags.Arg(0))
+	if err != nil { return readFailure(err) }
+
+	return runProgram(opts.interpreter(), dirname, filename, flags.Args()[1:], program, false)
+}
+
+func evalCommand(args []string) int {
+	flags := newFlagSet("eval")
+	opts := options{}
+	opts.register(flags)
+	expr := flags.String("e", "", "the `expr`ession to evaluate")
+	flags.Parse(args)
+	if *expr == "" {
+		flags.Usage()
+		return EXIT_USAGE
+	}
+
+	dirname, _ := os.Getwd()
+	return runProgram(opts.interpreter(), dirname, "-e", flags.Args(), *expr, true)
+}
 
-	if len(os.Args) > 1 {
-		filename = os.Args[1]
-		args = os.Args[2:]
+func replCommand(args []string) int {
+	flags := newFlagSet("repl")
+	opts := options{}
+	opts.register(flags)
+	history := flags.String("history", defaultHistoryFile(), "keep inputs between sessions in `file` (empty for none)")
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return EXIT_USAGE
 	}
 
-	if filename != "-" {
-		filename, _ = filepath.Abs(filename)
+	runREPL(opts.interpreter(), os.Stdin, os.Stdout, *history)
+	return EXIT_OK
+}
+
+func checkCommand(args []string) int {
+	flags := newFlagSet("check")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return EXIT_USAGE
+	}
+
+	status := EXIT_OK
+	for _, name := range flags.Args() {
+		_, _, program, err := readProgram(name)
+		if err != nil {
+			status = readFailure(err)
+			continue
+		}
+		if err := golsp.CheckSyntax(golsp.Tokenize(program)); err != nil {
+			status = syntaxError(name, err)
+		}
+	}
+
+	return status
+}
+
+func astCommand(args []string) int {
+	flags := newFlagSet("ast")
+	expr := flags.String("e", "", "print the syntax tree of `expr` instead of a file")
+	flags.Parse(args)
+
+	_, _, program, err := readSource(*expr, flags.Args())
+	if err != nil { return readFailure(err) }
+
+	fmt.Println(strings.TrimPrefix(PrintST(golsp.MakeST(golsp.Tokenize(program))), "\n"))
+	return EXIT_OK
+}
+
+func tokensCommand(args []string) int {
+	flags := newFlagSet("tokens")
+	expr := flags.String("e", "", "print the tokens of `expr` instead of a file")
+	flags.Parse(args)
+
+	_, _, program, err := readSource(*expr, flags.Args())
+	if err != nil { return readFailure(err) }
+
+	// the first two tokens are the root scope's delimiter and the newline
+	// that begins the first line
+	line := 1
+	for _, token := range golsp.Tokenize(program)[2:] {
+		if token == "" { continue }
+		fmt.Printf("%d\t%q\n", line, token)
+		if token == "\n" {
+			line++
+		} else if strings.HasPrefix(token, "\"") {
+			line += strings.Count(token, "\n")
+		}
+	}
+
+	return EXIT_OK
+}
+
+func resolveCommand(args []string) int {
+	flags := newFlagSet("resolve")
+	opts := options{}
+	opts.register(flags)
+	flags.Parse(args)
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		flags.Usage()
+		return EXIT_USAGE
+	}
+
+	return resolveName(opts.interpreter(), flags.Arg(0), flags.Args()[1:])
+}
+
+// resolveName: print how 'require' resolves a module name from a file, or from
+// the working directory if no file is given (see 'printResolution')
+// `interp`: the interpreter, which is configured by the command's options
+// `name`: the module name
+// `file`: an optional file
+// this function returns EXIT_FAILURE if the module was not found
+func resolveName(interp *golsp.Interpreter, name string, file []string) int {
+	dirname, _ := os.Getwd()
+	if len(file) > 0 {
+		filename, _ := filepath.Abs(file[0])
 		dirname = filepath.Dir(filename)
-		file, _ = os.Open(filename)
 	}
 
-	input, _ := ioutil.ReadAll(file)
-	// fmt.Println(PrintST(golsp.MakeST(golsp.Tokenize(string(input)))))
-	golsp.Run(dirname, filename, args, string(input))
+	return printResolution(interp.Resolve(dirname, name))
+}
+
+// printResolution: print every path that was tried while resolving a module
+// name, and the path that it resolved to
+// this function returns EXIT_FAILURE if the module was not found
+func printResolution(resolution golsp.Resolution) int {
+	for _, path := range resolution.Tried {
+		fmt.Printf("tried %s\n", path)
+	}
+	if resolution.Path == "" && resolution.Err != nil {
+		fmt.Printf("%s: not found: %v\n", resolution.Name, resolution.Err)
+		return EXIT_FAILURE
+	}
+	if resolution.Path == "" {
+		fmt.Printf("%s: not found\n", resolution.Name)
+		return EXIT_FAILURE
+	}
+	fmt.Printf("%s => %s\n", resolution.Name, resolution.Path)
+
+	return EXIT_OK
+}
+
+func modCommand(args []string) int {
+	flags := newFlagSet("mod")
+	flags.Parse(args)
+	if flags.NArg() != 1 || flags.Arg(0) != "vendor" {
+		flags.Usage()
+		return EXIT_USAGE
+	}
+
+	dirname, _ := os.Getwd()
+	if err := modVendor(dirname, os.Stdout); err != nil { return fail("mod vendor: %v", err) }
+
+	return EXIT_OK
+}
+
+func helpCommand(args []string) int {
+	if len(args) == 0 {
+		usage(os.Stdout)
+		return EXIT_OK
+	}
+
+	cmd, exists := commands[args[0]]
+	if !exists { return fail("unknown command %s (see 'golsp help')", args[0]) }
+
+	// every command prints its usage for '-h'
+	return cmd.run([]string{"-h"})
+}
+
+// usage: print the usage of the CLI and a list of its commands
+func usage(out io.Writer) {
+	fmt.Fprintf(out, "usage: golsp [flags] [file|-] [args...]\n")
+	fmt.Fprintf(out, "       golsp <command> [arguments]\n\n")
+	fmt.Fprintf(out, "Without a command, golsp runs a file (like 'golsp run'), or starts the REPL\n")
+	fmt.Fprintf(out, "if no file is given and stdin is a terminal.\n\ncommands:\n")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands { names = append(names, name) }
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-8s %s\n", name, commands[name].description)
+	}
+	fmt.Fprintf(out, "\nRun 'golsp help <command>' for more information about a command.\n")
+}
+
+// isTerminal: check whether a file is a terminal, i.e whether golsp is being
+// used interactively
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode() & os.ModeCharDevice != 0
+}
+
+// defaultCommand: run a file, or start the REPL, when no command is given
+func defaultCommand(args []string) int {
+	flags := flag.NewFlagSet("golsp", flag.ExitOnError)
+	opts := options{}
+	opts.register(flags)
+	interactive := flags.Bool("i", false, "start the REPL even if stdin is not a terminal")
+	resolve := flags.String("resolve", "", "same as 'golsp resolve name [file]'")
+	flags.Usage = func () {
+		usage(flags.Output())
+		fmt.Fprintf(flags.Output(), "\nflags:\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	// the options are already parsed, so they are passed on instead of the flags
+	if *resolve != "" {
+		if flags.NArg() > 1 {
+			flags.Usage()
+			return EXIT_USAGE
+		}
+		return resolveName(opts.interpreter(), *resolve, flags.Args())
+	}
+
+	if flags.NArg() == 0 && (*interactive || isTerminal(os.Stdin)) {
+		runREPL(opts.interpreter(), os.Stdin, os.Stdout, defaultHistoryFile())
+		return EXIT_OK
+	}
+
+	filename := "-"
+	if flags.NArg() > 0 { filename = flags.Arg(0) }
+	dirname, filename, program, err := readProgram(filename)
+	if err != nil { return readFailure(err) }
+
+	var programargs []string
+	if flags.NArg() > 0 { programargs = flags.Args()[1:] }
+
+	return runProgram(opts.interpreter(), dirname, filename, programargs, program, false)
+}
+
+func main() {
+	args := os.Args[1:]
+	if len(args) > 0 {
+		if cmd, exists := commands[args[0]]; exists { os.Exit(cmd.run(args[1:])) }
+	}
+
+	os.Exit(defaultCommand(args))
 }
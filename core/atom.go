@@ -0,0 +1,101 @@
+
+// Atoms
+
+package golsp
+
+import "sync"
+
+// atom: A shared, mutable reference to an (immutable) value. Atoms are the
+// only way for 'go' blocks to share state -- every 'go' block evaluates in an isolated
+// copy of its scope, but copies of an atom refer to the same value. `version` is
+// incremented every time the value changes and is used to detect conflicting updates
+// The atom is exposed to Golsp programs as a map of builtin functions
+
+type atom struct {
+	mutex sync.Mutex
+	value Object
+	version int
+}
+
+// /atom
+
+// load: Read the value of an atom
+// this function returns the value and its version
+func (a *atom) load() (Object, int) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return a.value, a.version
+}
+
+// compareAndSet: Set the value of an atom if it has not changed since it was read
+// `version`: the version of the value that was read
+// `value`: the new value
+// this function returns whether the value was set
+func (a *atom) compareAndSet(version int, value Object) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.version != version { return false }
+	a.value = CopyObject(value)
+	a.version++
+
+	return true
+}
+
+// deref: The 'deref' method of an atom
+// this function returns the current value of the atom
+func (a *atom) deref(_ Scope, _ []Object) Object {
+	value, _ := a.load()
+	return value
+}
+
+// reset: The 'reset' method of an atom. This function unconditionally sets
+// the value of the atom, i.e `[counter.reset 0]`
+// this function returns the new value
+func (a *atom) reset(scope Scope, args []Object) Object {
+	arguments := EvalArgs(scope, args)
+	if len(arguments) < 1 { return UndefinedObject() }
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.value = CopyObject(arguments[0])
+	a.version++
+
+	return arguments[0]
+}
+
+// swap: The 'swap' method of an atom. This function atomically applies a function
+// to the value of the atom, i.e `[counter.swap + 1]` sets the value to
+// `[+ value 1]`. The function is called again if another 'go' block changes the value
+// in the meantime, so it should not have side effects
+// this function returns the new value
+func (a *atom) swap(scope Scope, args []Object) Object {
+	arguments := EvalArgs(scope, args)
+	if len(arguments) < 1 || arguments[0].Type != ObjectTypeFunction {
+		return UndefinedObject()
+	}
+
+	fn := arguments[0]
+	for {
+		value, version := a.load()
+		fnargs := ListFromSlice(append([]Object{value}, arguments[1:]...))
+		result := CallFunction(fn, fnargs)
+		if a.compareAndSet(version, result) { return result }
+	}
+}
+
+// BuiltinAtom: The builtin 'atom' function. This function creates an atom
+// with an initial value, i.e `[atom 0]`
+// this function returns a map containing the atom's methods
+func BuiltinAtom(scope Scope, args []Object) Object {
+	arguments := EvalArgs(scope, args)
+	a := &atom{value: UndefinedObject()}
+	if len(arguments) > 0 { a.value = CopyObject(arguments[0]) }
+
+	return MapObject(map[string]Object{
+		"deref": BuiltinFunctionObject("deref", a.deref),
+		"reset": BuiltinFunctionObject("reset", a.reset),
+		"swap": BuiltinFunctionObject("swap", a.swap),
+	})
+}
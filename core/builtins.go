@@ -8,34 +8,40 @@ import (
 	"strings"
 	"strconv"
 	"time"
-	"path/filepath"
-	"io/ioutil"
-	"os"
-	"plugin"
 )
 
-var Builtins = Scope{}
-
-// InitializeBuiltins: Initialize the default builtin scope ('Builtins')
-// with builtin identifiers
-func InitializeBuiltins(dirname string, filename string, args []string) {
+// initializeBuiltins: Initialize an interpreter's builtin scope with
+// builtin identifiers. Builtin functions are bound to the interpreter, so that
+// they can find it even when they are called outside of any scope
+// (see 'CallFunction')
+func (i *Interpreter) initializeBuiltins() {
 	identifiers := map[string]Object{
 		UNDEFINED: UndefinedObject(),
-		DIRNAME: StringObject(dirname),
-		FILENAME: StringObject(filename),
-		ARGS: ListObject(args),
 
 		"def": BuiltinFunctionObject("def", BuiltinDef),
 		"const": BuiltinFunctionObject("const", BuiltinConst),
 		"lambda": BuiltinFunctionObject("lambda", BuiltinLambda),
 		"require": BuiltinFunctionObject("require", BuiltinRequire),
+		"export": BuiltinFunctionObject("export", BuiltinExport),
+		"import": BuiltinFunctionObject("import", BuiltinImport),
 		"if": BuiltinFunctionObject("if", BuiltinIf),
 		"when": BuiltinFunctionObject("when", BuiltinWhen),
 		"do": BuiltinFunctionObject("do", BuiltinDo),
 		"go": BuiltinFunctionObject("go", BuiltinGo),
+		"detach": BuiltinFunctionObject("detach", BuiltinDetach),
+		"atexit": BuiltinFunctionObject("atexit", BuiltinAtexit),
+		"cancelled?": BuiltinFunctionObject("cancelled?", BuiltinCancelled),
+		"all": BuiltinFunctionObject("all", BuiltinAll),
+		"any": BuiltinFunctionObject("any", BuiltinAny),
+		"race": BuiltinFunctionObject("race", BuiltinRace),
+		"supervise": BuiltinFunctionObject("supervise", BuiltinSupervise),
+		"atom": BuiltinFunctionObject("atom", BuiltinAtom),
 		"sleep": BuiltinFunctionObject("sleep", BuiltinSleep),
+		"after": BuiltinFunctionObject("after", BuiltinAfter),
+		"every": BuiltinFunctionObject("every", BuiltinEvery),
 		"sprintf": BuiltinFunctionObject("sprintf", BuiltinSprintf),
 		"printf": BuiltinFunctionObject("printf", BuiltinPrintf),
+		"error": BuiltinFunctionObject("error", BuiltinError),
 
 		"+": BuiltinMathFunction("+"),
 		"-": BuiltinMathFunction("-"),
@@ -51,9 +57,18 @@ func InitializeBuiltins(dirname string, filename string, args []string) {
 		"<=": BuiltinComparisonFunction("<="),
 	}
 
-	Builtins.Identifiers = identifiers
-	Builtins.Constants = make(map[string]bool)
-	for k, _ := range identifiers { Builtins.Constants[k] = true }
+	i.Builtins = Scope{
+		Identifiers: identifiers,
+		Constants: make(map[string]bool, len(identifiers)),
+		interp: i,
+	}
+	for k, obj := range identifiers {
+		if obj.Type == ObjectTypeFunction {
+			obj.Scope = Scope{interp: i}
+			identifiers[k] = obj
+		}
+		i.Builtins.Constants[k] = true
+	}
 }
 
 // comparePatterns: Compare two function potterns (as passed to the '=' function)
@@ -86,8 +101,13 @@ func comparePatterns(pattern1 []STNode, pattern2 []STNode) bool {
 
 			zip1 := make([]STNode, len(node1.Children))
 			zip2 := make([]STNode, len(node2.Children))
-			for j, z := range node1.Children { zip1[j] = *z.Zip }
-			for j, z := range node2.Children { zip2[j] = *z.Zip }
+			// spread nodes (i.e `rest...`) have no zip
+			for j, z := range node1.Children {
+				if z.Zip != nil { zip1[j] = *z.Zip }
+			}
+			for j, z := range node2.Children {
+				if z.Zip != nil { zip2[j] = *z.Zip }
+			}
 
 			if !comparePatterns(zip1, zip2) { return false }
 		}
@@ -139,7 +159,12 @@ func assign(scope Scope, arguments []Object, constant bool) Object {
 	symbol := arguments[0].Value
 	value := arguments[1].Value
 
-	// attempting to assign to a literal or list fails
+	// list and map symbols destructure the value, i.e `[def { a b rest... } list]`
+	if symbol.Type == STNodeTypeList || symbol.Type == STNodeTypeMap {
+		return destructure(scope, symbol, Eval(MakeScope(&scope), value), constant)
+	}
+
+	// attempting to assign to a literal fails
 	if symbol.Type != STNodeTypeIdentifier &&
 		symbol.Type != STNodeTypeExpression {
 		return UndefinedObject()
@@ -222,6 +247,73 @@ func assign(scope Scope, arguments []Object, constant bool) Object {
 	return scope.Identifiers[symbol.Head]
 }
 
+// destructures: Check whether a list or map pattern can be bound to an object by
+// 'def' or 'const'. This is the same as matching a function pattern (see
+// 'comparePatternNode'), except that map patterns can leave out keys
+// `pattern`: the pattern
+// `obj`: the object
+// this function returns whether the pattern matches
+func destructures(pattern STNode, obj Object) bool {
+	if pattern.Type == STNodeTypeMap {
+		if obj.Type != ObjectTypeMap { return false }
+		for i, child := range pattern.Children {
+			// like function patterns, every node before a spread needs a key
+			if child.Spread && child.Type == STNodeTypeIdentifier { break }
+			if len(obj.MapKeys) <= i { return false }
+			if child.Type != STNodeTypeStringLiteral && child.Type != STNodeTypeNumberLiteral {
+				continue
+			}
+			value, exists := obj.Map[child.Head]
+			if !exists { return false }
+			if child.Zip != nil && !destructures(*child.Zip, value) { return false }
+		}
+
+		return true
+	}
+
+	if pattern.Type == STNodeTypeList {
+		if obj.Type != ObjectTypeList { return false }
+		elements := obj.Elements.ToSlice()
+		for i, child := range pattern.Children {
+			if child.Spread && child.Type == STNodeTypeIdentifier { return len(elements) >= i }
+			if len(elements) <= i || !destructures(child, elements[i]) { return false }
+		}
+
+		return len(elements) == len(pattern.Children)
+	}
+
+	return comparePatternNode(pattern, obj)
+}
+
+// destructure: Bind the identifiers in a list or map pattern to the parts of an
+// object, the same way that function arguments are bound (see 'bindArguments'),
+// i.e `[const ( "map": map "filter": keep ) [require "stdlib/tools.golsp"]]`
+// `scope`: the scope in which the identifiers are bound
+// `pattern`: the pattern
+// `obj`: the object
+// `constant`: whether the identifiers are bound as constants
+// this function returns the object, or an error if the pattern does not match
+// or binds a constant identifier
+func destructure(scope Scope, pattern STNode, obj Object, constant bool) Object {
+	if !destructures(pattern, obj) {
+		return ErrorObject(fmt.Sprintf("cannot destructure %s", typeName(obj)))
+	}
+
+	bound := MakeScope(&scope)
+	bindArguments(bound, []STNode{pattern}, ListFromSlice([]Object{obj}))
+	for identifier := range bound.Identifiers {
+		if isConstant(scope, identifier) {
+			return ErrorObject("cannot redefine constant " + identifier)
+		}
+	}
+	for identifier, value := range bound.Identifiers {
+		scope.Identifiers[identifier] = value
+		if constant { scope.Constants[identifier] = true }
+	}
+
+	return obj
+}
+
 // BuiltinLambda: The builtin 'lambda' function. This produces a function-type
 // object with one pattern and one expression
 // this function returns the function object that is produced
@@ -266,7 +358,9 @@ func BuiltinLambda(scope Scope, arguments []Object) Object {
 }
 
 // BuiltinRequire: The builtin 'require' function. This function evaluates a
-// file and returns the Object that the file exports.
+// file and returns the Object that the file exports. Paths are resolved with
+// 'Resolve' -- paths that begin with 'native:' name native modules (see
+// 'RegisterModule'), and '.so' files are loaded as plugins
 func BuiltinRequire(scope Scope, args []Object) Object {
 	arguments := EvalArgs(scope, args)
 
@@ -277,30 +371,110 @@ func BuiltinRequire(scope Scope, args []Object) Object {
 		return UndefinedObject()
 	}
 
-	dirnode := LookupIdentifier(scope, DIRNAME)
-	dirname := dirnode.Value.Head[1:len(dirnode.Value.Head) - 1]
+	interp := scope.Interpreter()
+	dirname, _ := ToString(LookupIdentifier(scope, DIRNAME))
 	rawpath := arguments[0].Value.Head[1:len(arguments[0].Value.Head) - 1]
-	if strings.HasPrefix(rawpath, "stdlib/") {
-		// TODO find a better way to do this
-		dirname = os.Getenv("GOLSPPATH")
+	resolution := interp.Resolve(dirname, rawpath)
+	if resolution.Path == "" && resolution.Err != nil {
+		return ErrorObject("cannot find module " + rawpath + ": " + resolution.Err.Error())
+	}
+	if resolution.Path == "" { return ErrorObject("cannot find module " + rawpath) }
+
+	if strings.HasPrefix(resolution.Path, NATIVE_PREFIX) {
+		exports, _ := NativeModule(resolution.Path[len(NATIVE_PREFIX):])
+		exports, _ = bindBuiltins(exports, interp)
+		return exports
+	}
+	if strings.HasSuffix(resolution.Path, ".so") {
+		exports, _ := bindBuiltins(loadPlugin(resolution.Path), interp)
+		return exports
+	}
+
+	importer, _ := ToString(LookupIdentifier(scope, FILENAME))
+
+	return interp.loadModule(importer, resolution.Path)
+}
+
+// BuiltinImport: The builtin 'import' function. This function requires a module
+// and binds some of its exports to constants, optionally renaming them, i.e
+// `[import "stdlib/tools.golsp" map filter: keep]` binds 'map' and 'keep' (the
+// module's 'filter'). It is equivalent to destructuring the module with 'const'
+// (see 'destructure')
+// this function returns the module, or an error if the module does not export one
+// of the identifiers or an identifier is a constant
+func BuiltinImport(scope Scope, args []Object) Object {
+	if len(args) < 2 { return ErrorObject("import requires a module and identifiers") }
+
+	pattern := STNode{Type: STNodeTypeMap}
+	for _, arg := range args[1:] {
+		if arg.Type != ObjectTypeBuiltinArgument || arg.Value.Type != STNodeTypeIdentifier ||
+			(arg.Value.Zip != nil && arg.Value.Zip.Type != STNodeTypeIdentifier) {
+			return ErrorObject("import requires identifiers, i.e 'name' or 'name: newname'")
+		}
+
+		local := STNode{Head: arg.Value.Head, Type: STNodeTypeIdentifier}
+		if arg.Value.Zip != nil { local.Head = arg.Value.Zip.Head }
+		key := StringObject(arg.Value.Head).Value
+		key.Zip = &local
+		pattern.Children = append(pattern.Children, key)
 	}
 
-	resolvedpath := filepath.Join(dirname, rawpath)
+	module := BuiltinRequire(scope, args[:1])
+	if module.Type == ObjectTypeError { return module }
+	if module.Type == ObjectTypeMap {
+		for _, key := range pattern.Children {
+			if _, exists := module.Map[key.Head]; !exists {
+				return ErrorObject("module does not export " + key.Head[1:len(key.Head) - 1])
+			}
+		}
+	}
 
-	if strings.HasSuffix(resolvedpath, ".so") {
-		plug, err := plugin.Open(resolvedpath)
-		if err != nil { return UndefinedObject() }
-		exportssym, err := plug.Lookup("Exports")
-		if err != nil { return UndefinedObject() }
-		return *exportssym.(*Object)
+	return destructure(scope, pattern, module, true)
+}
+
+// BuiltinExport: The builtin 'export' function. This function adds identifiers to
+// the object that the current module exports, i.e `[export square double]`, or
+// defines and exports an identifier, i.e `[export const [square n] [* n n]]`.
+// A module that uses 'export' exports a map of the identifiers that it exports
+// (bound to their values at the end of the module) instead of the result of its last
+// statement, so identifiers that are not exported are private to the module.
+// 'export' can only be used at the top level of a module
+// this function returns the object that the last identifier is bound to
+func BuiltinExport(scope Scope, args []Object) Object {
+	// blocks have isolated copies of the module's identifiers (see 'IsolateScope'),
+	// so only the module's own scope descends from the builtin scope
+	interp := scope.Interpreter()
+	filename, ismodule := scope.Identifiers[FILENAME]
+	if !ismodule || scope.Parent != &interp.Builtins {
+		return ErrorObject("export can only be used at the top level of a module")
+	}
+
+	identifiers := []string{}
+	result := UndefinedObject()
+	if len(args) > 2 && args[0].Type == ObjectTypeBuiltinArgument &&
+		(args[0].Value.Head == "def" || args[0].Value.Head == "const") &&
+		args[0].Value.Type == STNodeTypeIdentifier {
+		result = assign(scope, args[1:], args[0].Value.Head == "const")
+
+		symbol := args[1].Value
+		if symbol.Type == STNodeTypeExpression && len(symbol.Children) > 0 {
+			symbol = symbol.Children[0]
+		}
+		identifiers = append(identifiers, symbol.Head)
+	} else {
+		for _, arg := range args {
+			if arg.Type != ObjectTypeBuiltinArgument || arg.Value.Type != STNodeTypeIdentifier {
+				return ErrorObject("export requires identifiers")
+			}
+			identifiers = append(identifiers, arg.Value.Head)
+			result = LookupIdentifier(scope, arg.Value.Head)
+		}
 	}
 
-	file, err := os.Open(resolvedpath)
-	if err != nil { return UndefinedObject() }
-	data, err := ioutil.ReadAll(file)
-	if err != nil { return UndefinedObject() }
+	name, _ := ToString(filename)
+	for _, identifier := range identifiers { interp.export(name, identifier, scope) }
 
-	return Run(filepath.Dir(resolvedpath), resolvedpath, []string{}, string(data))
+	return result
 }
 
 // BuiltinMathFunction: Produce a builtin function for a given math operator
@@ -371,6 +545,17 @@ func formatStr(text string, objects []Object) string {
 			continue
 		}
 
+		if v.Type == ObjectTypeError {
+			message, _ := ToString(v.Map[StringObject("message").Value.Head])
+			line, located := v.Map[StringObject("line").Value.Head]
+			if located {
+				file := v.Map[StringObject("file").Value.Head]
+				message = formatStr("%v (%v:%v)", []Object{StringObject(message), file, line})
+			}
+			args[i] = fmt.Sprintf("<error:%v>", message)
+			continue
+		}
+
 		if v.Type == ObjectTypeMap {
 			strs := make([]string, 0, len(v.MapKeys))
 			for _, key := range v.MapKeys {
@@ -394,6 +579,13 @@ func formatStr(text string, objects []Object) string {
 	return fmt.Sprintf(text, args...)
 }
 
+// Format: Format an object the way that 'printf' formats a '%v' verb
+// `obj`: the object
+// this function returns the formatted string
+func Format(obj Object) string {
+	return formatStr("%v", []Object{obj})
+}
+
 // BuiltinSprintf: The builtin 'sprintf' function. This function formats a
 // Go-style format string with a set of arguments
 // this function returns the formatted string
@@ -417,12 +609,26 @@ func BuiltinSprintf(scope Scope, args []Object) Object {
 func BuiltinPrintf(scope Scope, arguments []Object) Object {
 	obj := BuiltinSprintf(scope, arguments)
 	if obj.Value.Head != UNDEFINED {
-		fmt.Printf(obj.Value.Head[1:len(obj.Value.Head) - 1])
+		fmt.Fprint(scope.Interpreter().Stdout, obj.Value.Head[1:len(obj.Value.Head) - 1])
 	}
 
 	return obj
 }
 
+// BuiltinError: The builtin 'error' function. This function produces an error
+// whose message is formatted like 'sprintf', i.e `[error "bad value: %v" x]`
+// this function returns the error object
+func BuiltinError(scope Scope, args []Object) Object {
+	arguments := EvalArgs(scope, args)
+	if len(arguments) == 0 { return ErrorObject("error") }
+
+	message := BuiltinSprintf(scope, arguments)
+	if message.Value.Head == UNDEFINED { return UndefinedObject() }
+	text, _ := ToString(message)
+
+	return ErrorObject(text)
+}
+
 // BuiltinDo: The builtin 'do' function. This function evaluates a series of
 // statements within an enclosed, isolated scope
 // this function returns the result of evaluating the final statement
@@ -446,30 +652,6 @@ func BuiltinDo(scope Scope, arguments []Object) Object {
 	return Eval(scope, scopenode)
 }
 
-// BuiltinGo: The builtin 'go' function. This function concurrently evaluates
-// a series of statements within an enclosed, isolated scope
-// this function returns UNDEFINED
-func BuiltinGo(scope Scope, arguments []Object) Object {
-	var result Object
-	completed := false
-
-	RuntimeWaitGroup().Add(1)
-	go func () {
-		defer RuntimeWaitGroup().Done()
-		result = BuiltinDo(scope, arguments)
-		completed = true
-	}()
-
-	wait := func(_ Scope, _ []Object) Object {
-		for !completed {}
-		return result
-	}
-
-	return MapObject(map[string]Object{
-		"wait": BuiltinFunctionObject("wait", wait),
-	})
-}
-
 // BuiltinSleep: the builtin 'sleep' function. This function waits for a
 // specified number of milliseconds
 // this function returns UNDEFINED
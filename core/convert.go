@@ -0,0 +1,439 @@
+
+// Conversion between Go values and Golsp objects
+
+package golsp
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+var objectType = reflect.TypeOf(Object{})
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// typeName: Describe the type of an object, for error messages
+// `obj`: the object
+// this function returns the name of the object's type
+func typeName(obj Object) string {
+	switch obj.Type {
+	case ObjectTypeFunction: return "function"
+	case ObjectTypeList: return "list"
+	case ObjectTypeMap: return "map"
+	case ObjectTypeError: return "error"
+	}
+
+	switch obj.Value.Type {
+	case STNodeTypeStringLiteral: return "string"
+	case STNodeTypeNumberLiteral: return "number"
+	}
+	if obj.Value.Head == UNDEFINED { return UNDEFINED }
+
+	return "identifier"
+}
+
+// fieldKey: Find the map key that a struct field is converted to and from --
+// the field's name, or the name in its `golsp:"name"` tag
+// `field`: the struct field
+// this function returns the key and whether the field is converted at all
+// (unexported fields and fields tagged `golsp:"-"` are not)
+func fieldKey(field reflect.StructField) (string, bool) {
+	if field.PkgPath != "" { return "", false }
+
+	tag := field.Tag.Get("golsp")
+	if tag == "-" { return "", false }
+	if tag != "" { return tag, true }
+
+	return field.Name, true
+}
+
+// FromGo: Convert a Go value to an object. Numbers, strings and bools (as 1 or 0)
+// become literals, nil becomes UNDEFINED, slices and arrays become lists, maps with
+// string or number keys become maps (sorted by key), structs become maps of their
+// fields (see 'fieldKey'), errors become error objects and functions become builtin
+// functions (see 'GoFunction'). Pointers and interfaces are converted to the value
+// that they point to. Values that contain themselves cannot be converted
+// `value`: the Go value
+// this function returns the produced Object and an optional error if the value
+// cannot be converted
+func FromGo(value interface{}) (Object, error) {
+	if value == nil { return UndefinedObject(), nil }
+
+	return fromValue(reflect.ValueOf(value))
+}
+
+// visit: a pointer, map or slice that is being converted. Values that contain
+// themselves (i.e a struct that points to itself) cannot be converted, and are
+// detected by the visits that are in progress instead of overflowing the stack
+type visit struct {
+	t reflect.Type
+	pointer uintptr
+}
+
+func fromValue(value reflect.Value) (Object, error) {
+	return fromVisiting(value, make(map[visit]bool))
+}
+
+func fromVisiting(value reflect.Value, visiting map[visit]bool) (Object, error) {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func:
+		if value.IsNil() { return UndefinedObject(), nil }
+	}
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		current := visit{value.Type(), value.Pointer()}
+		if visiting[current] { return Object{}, fmt.Errorf("Cannot convert %s that contains itself", value.Type()) }
+		visiting[current] = true
+		defer delete(visiting, current)
+	}
+	if value.Type() == objectType { return value.Interface().(Object), nil }
+	if value.Type().Implements(errorType) && value.Kind() != reflect.Interface {
+		return ErrorObject(value.Interface().(error).Error()), nil
+	}
+
+	switch value.Kind() {
+	case reflect.Bool:
+		if value.Bool() { return NumberObject(1.0), nil }
+		return NumberObject(0.0), nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return NumberObject(float64(value.Int())), nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return NumberObject(float64(value.Uint())), nil
+
+	case reflect.Float32, reflect.Float64:
+		return NumberObject(value.Float()), nil
+
+	case reflect.String:
+		return StringObject(value.String()), nil
+
+	case reflect.Ptr, reflect.Interface:
+		return fromVisiting(value.Elem(), visiting)
+
+	case reflect.Slice, reflect.Array:
+		list := List{}
+		for i := 0; i < value.Len(); i++ {
+			elem, err := fromVisiting(value.Index(i), visiting)
+			if err != nil { return Object{}, err }
+			list.Append(elem)
+		}
+
+		return Object{Type: ObjectTypeList, Elements: list}, nil
+
+	case reflect.Map:
+		keys := value.MapKeys()
+		keyobjects := make([]Object, len(keys))
+		for i, key := range keys {
+			keyobj, err := fromVisiting(key, visiting)
+			if err != nil { return Object{}, err }
+			if keyobj.Type != ObjectTypeLiteral || keyobj.Value.Head == UNDEFINED {
+				return Object{}, fmt.Errorf("Cannot convert map with %s keys", key.Type())
+			}
+			keyobjects[i] = keyobj
+		}
+		order := make([]int, len(keys))
+		for i := range order { order[i] = i }
+		sort.Slice(order, func (a int, b int) bool {
+			return lessKey(keys[order[a]], keys[order[b]])
+		})
+
+		object := Object{
+			Type: ObjectTypeMap,
+			Map: make(map[string]Object),
+			MapKeys: make([]Object, 0, len(keys)),
+		}
+		for _, i := range order {
+			elem, err := fromVisiting(value.MapIndex(keys[i]), visiting)
+			if err != nil { return Object{}, err }
+			object.Map[keyobjects[i].Value.Head] = elem
+			object.MapKeys = append(object.MapKeys, keyobjects[i])
+		}
+
+		return object, nil
+
+	case reflect.Struct:
+		object := Object{
+			Type: ObjectTypeMap,
+			Map: make(map[string]Object),
+			MapKeys: make([]Object, 0, value.NumField()),
+		}
+		for i := 0; i < value.NumField(); i++ {
+			key, ok := fieldKey(value.Type().Field(i))
+			if !ok { continue }
+
+			elem, err := fromVisiting(value.Field(i), visiting)
+			if err != nil { return Object{}, err }
+			keyobj := StringObject(key)
+			object.Map[keyobj.Value.Head] = elem
+			object.MapKeys = append(object.MapKeys, keyobj)
+		}
+
+		return object, nil
+
+	case reflect.Func:
+		name := runtime.FuncForPC(value.Pointer()).Name()
+		name = name[strings.LastIndex(name, ".") + 1:]
+		return BuiltinFunctionObject(name, goFunction(name, value)), nil
+	}
+
+	return Object{}, fmt.Errorf("Cannot convert %s to object", value.Type())
+}
+
+// lessKey: Order the keys of a Go map, numerically or lexically
+func lessKey(a reflect.Value, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() < b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return a.Uint() < b.Uint()
+	case reflect.Float32, reflect.Float64:
+		return a.Float() < b.Float()
+	}
+
+	return fmt.Sprint(a.Interface()) < fmt.Sprint(b.Interface())
+}
+
+// ToGo: Convert an object to a Go value and store it in the value that `target`
+// points to, the same way that 'encoding/json' unmarshals values. The conversions are
+// the reverse of 'FromGo' -- UNDEFINED becomes the zero value, maps can be converted
+// to Go maps or structs, Golsp functions can be converted to Go functions and every
+// object can be converted to an Object. Objects stored in an interface{} become float64, string, []interface{},
+// map[string]interface{}, error, Object (for functions) or nil
+// `obj`: the object
+// `target`: a non-nil pointer
+// this function returns an optional error if the object cannot be converted
+func ToGo(obj Object, target interface{}) error {
+	value := reflect.ValueOf(target)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return errors.New("Cannot convert object to non-pointer target")
+	}
+
+	return toValue(obj, value.Elem())
+}
+
+func toValue(obj Object, target reflect.Value) error {
+	t := target.Type()
+	fail := func () error {
+		return fmt.Errorf("Cannot convert %s to %s", typeName(obj), t)
+	}
+
+	if t == objectType {
+		target.Set(reflect.ValueOf(obj))
+		return nil
+	}
+	if obj.Type == ObjectTypeLiteral && obj.Value.Head == UNDEFINED {
+		target.Set(reflect.Zero(t))
+		return nil
+	}
+	if t == errorType {
+		if obj.Type != ObjectTypeError { return fail() }
+		message, _ := ToString(obj.Map[StringObject("message").Value.Head])
+		target.Set(reflect.ValueOf(errors.New(message)))
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		target.SetBool(ToBoolean(obj))
+		return nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		num, err := ToNumber(obj)
+		if err != nil { return fail() }
+		if num != math.Trunc(num) || target.OverflowInt(int64(num)) {
+			return fmt.Errorf("Cannot convert %v to %s", num, t)
+		}
+		target.SetInt(int64(num))
+		return nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		num, err := ToNumber(obj)
+		if err != nil { return fail() }
+		if num != math.Trunc(num) || num < 0 || target.OverflowUint(uint64(num)) {
+			return fmt.Errorf("Cannot convert %v to %s", num, t)
+		}
+		target.SetUint(uint64(num))
+		return nil
+
+	case reflect.Float32, reflect.Float64:
+		num, err := ToNumber(obj)
+		if err != nil { return fail() }
+		target.SetFloat(num)
+		return nil
+
+	case reflect.String:
+		str, err := ToString(obj)
+		if err != nil { return fail() }
+		target.SetString(str)
+		return nil
+
+	case reflect.Ptr:
+		elem := reflect.New(t.Elem())
+		if err := toValue(obj, elem.Elem()); err != nil { return err }
+		target.Set(elem)
+		return nil
+
+	case reflect.Interface:
+		if t.NumMethod() > 0 { return fail() }
+		value, err := toInterface(obj)
+		if err != nil { return err }
+		target.Set(reflect.ValueOf(value))
+		return nil
+
+	case reflect.Slice:
+		if obj.Type != ObjectTypeList { return fail() }
+		slice := reflect.MakeSlice(t, obj.Elements.Length, obj.Elements.Length)
+		for i, elem := range obj.Elements.ToSlice() {
+			if err := toValue(elem, slice.Index(i)); err != nil { return err }
+		}
+		target.Set(slice)
+		return nil
+
+	case reflect.Array:
+		if obj.Type != ObjectTypeList { return fail() }
+		if obj.Elements.Length != t.Len() {
+			return fmt.Errorf("Cannot convert list of length %d to %s", obj.Elements.Length, t)
+		}
+		for i, elem := range obj.Elements.ToSlice() {
+			if err := toValue(elem, target.Index(i)); err != nil { return err }
+		}
+		return nil
+
+	case reflect.Map:
+		if obj.Type != ObjectTypeMap && obj.Type != ObjectTypeError { return fail() }
+		gomap := reflect.MakeMapWithSize(t, len(obj.MapKeys))
+		for _, keyobj := range obj.MapKeys {
+			key := reflect.New(t.Key()).Elem()
+			if err := toValue(keyobj, key); err != nil { return err }
+			elem := reflect.New(t.Elem()).Elem()
+			if err := toValue(obj.Map[keyobj.Value.Head], elem); err != nil { return err }
+			gomap.SetMapIndex(key, elem)
+		}
+		target.Set(gomap)
+		return nil
+
+	case reflect.Struct:
+		if obj.Type != ObjectTypeMap && obj.Type != ObjectTypeError { return fail() }
+		for i := 0; i < t.NumField(); i++ {
+			key, ok := fieldKey(t.Field(i))
+			if !ok { continue }
+			elem, exists := obj.Map[StringObject(key).Value.Head]
+			if !exists { continue }
+			if err := toValue(elem, target.Field(i)); err != nil {
+				return fmt.Errorf("%s: %v", key, err)
+			}
+		}
+		return nil
+
+	case reflect.Func:
+		if obj.Type != ObjectTypeFunction { return fail() }
+		target.Set(reflect.MakeFunc(t, func (in []reflect.Value) []reflect.Value {
+			return callFromGo(obj, t, in)
+		}))
+		return nil
+	}
+
+	return fail()
+}
+
+// toInterface: Convert an object to the Go value that it most naturally corresponds to
+func toInterface(obj Object) (interface{}, error) {
+	switch obj.Type {
+	case ObjectTypeFunction:
+		return obj, nil
+
+	case ObjectTypeError:
+		var err error
+		toValue(obj, reflect.ValueOf(&err).Elem())
+		return err, nil
+
+	case ObjectTypeList:
+		slice := make([]interface{}, 0, obj.Elements.Length)
+		for _, elem := range obj.Elements.ToSlice() {
+			value, err := toInterface(elem)
+			if err != nil { return nil, err }
+			slice = append(slice, value)
+		}
+		return slice, nil
+
+	case ObjectTypeMap:
+		gomap := make(map[string]interface{}, len(obj.MapKeys))
+		for _, keyobj := range obj.MapKeys {
+			key, err := ToString(keyobj)
+			if err != nil { key = keyobj.Value.Head }
+			value, err := toInterface(obj.Map[keyobj.Value.Head])
+			if err != nil { return nil, err }
+			gomap[key] = value
+		}
+		return gomap, nil
+	}
+
+	switch obj.Value.Type {
+	case STNodeTypeStringLiteral: return ToString(obj)
+	case STNodeTypeNumberLiteral: return ToNumber(obj)
+	}
+	if obj.Value.Head == UNDEFINED { return nil, nil }
+
+	return nil, fmt.Errorf("Cannot convert %s to interface{}", typeName(obj))
+}
+
+// callFromGo: Call a Golsp function from a Go function of type `t` (see 'ToGo').
+// If the last result of `t` is an error, conversion errors and error objects returned
+// by the Golsp function are returned as errors -- otherwise, they cause a panic.
+// Multiple (non-error) results are taken from a list returned by the function
+func callFromGo(fn Object, t reflect.Type, in []reflect.Value) []reflect.Value {
+	out := make([]reflect.Value, t.NumOut())
+	for i := range out { out[i] = reflect.New(t.Out(i)).Elem() }
+	returnsError := t.NumOut() > 0 && t.Out(t.NumOut() - 1) == errorType
+	nvalues := len(out)
+	if returnsError { nvalues-- }
+
+	fail := func (err error) []reflect.Value {
+		if !returnsError { panic(err) }
+		out[len(out) - 1] = reflect.ValueOf(&err).Elem()
+		return out
+	}
+
+	args := make([]Object, 0, len(in))
+	for i, value := range in {
+		if t.IsVariadic() && i == len(in) - 1 {
+			for j := 0; j < value.Len(); j++ {
+				arg, err := fromValue(value.Index(j))
+				if err != nil { return fail(err) }
+				args = append(args, arg)
+			}
+			continue
+		}
+
+		arg, err := fromValue(value)
+		if err != nil { return fail(err) }
+		args = append(args, arg)
+	}
+
+	result := CallFunction(fn, ListFromSlice(args))
+	if result.Type == ObjectTypeError {
+		var err error
+		toValue(result, reflect.ValueOf(&err).Elem())
+		return fail(err)
+	}
+
+	switch nvalues {
+	case 0:
+	case 1:
+		if err := toValue(result, out[0]); err != nil { return fail(err) }
+	default:
+		if result.Type != ObjectTypeList || result.Elements.Length != nvalues {
+			return fail(fmt.Errorf("Cannot convert %s to %d results", typeName(result), nvalues))
+		}
+		for i, elem := range result.Elements.ToSlice() {
+			if err := toValue(elem, out[i]); err != nil { return fail(err) }
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,191 @@
+package golsp
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type point struct {
+	X int
+	Y int `golsp:"y"`
+	Skip int `golsp:"-"`
+	hidden int
+}
+
+type node struct {
+	Name string
+	Next *node
+}
+
+func double(x int) int { return 2 * x }
+
+func TestFromGo(t *testing.T) {
+	three := 3
+	shared := &point{X: 1}
+	cases := []struct {
+		name string
+		value interface{}
+		expected string
+	}{
+		{"nil", nil, "<undefined>"},
+		{"nil pointer", (*point)(nil), "<undefined>"},
+		{"nil slice", []int(nil), "<undefined>"},
+		{"bool", true, "1"},
+		{"int", -4, "-4"},
+		{"uint", uint8(200), "200"},
+		{"float", 1.5, "1.5"},
+		{"string", "hello", "hello"},
+		{"pointer", &three, "3"},
+		{"slice", []int{1, 2, 3}, "{1 2 3 }"},
+		{"array", [2]string{"a", "b"}, "{a b }"},
+		{"nested slice", [][]int{{1}, {2, 3}}, "{{1 } {2 3 } }"},
+		{"string keys", map[string]int{"b": 2, "a": 1}, `map("a": 1, "b": 2)`},
+		{"number keys", map[int]string{10: "x", 2: "y"}, "map(2: y, 10: x)"},
+		{"struct", point{X: 1, Y: 2, Skip: 3, hidden: 4}, `map("X": 1, "y": 2)`},
+		{"struct pointer", &point{X: 5}, `map("X": 5, "y": 0)`},
+		{"shared pointer", []*point{shared, shared}, `{map("X": 1, "y": 0) map("X": 1, "y": 0) }`},
+		{"error", errors.New("bad value"), "<error:bad value>"},
+		{"object", StringObject("kept"), "kept"},
+	}
+
+	for _, c := range cases {
+		obj, err := FromGo(c.value)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+			continue
+		}
+		if formatted := Format(obj); formatted != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.name, c.expected, formatted)
+		}
+	}
+}
+
+func TestFromGoFunction(t *testing.T) {
+	obj, err := FromGo(double)
+	if err != nil { t.Fatalf("unexpected error: %v", err) }
+	if obj.Type != ObjectTypeFunction { t.Fatalf("expected a function, got %s", typeName(obj)) }
+	if obj.Function.Name != "double" { t.Errorf("expected name double, got %q", obj.Function.Name) }
+}
+
+func TestFromGoErrors(t *testing.T) {
+	cyclic := &node{Name: "a"}
+	cyclic.Next = &node{Name: "b", Next: cyclic}
+	list := []interface{}{nil}
+	list[0] = list
+
+	cases := []struct {
+		name string
+		value interface{}
+		expected string
+	}{
+		{"cyclic struct", cyclic, "contains itself"},
+		{"cyclic slice", list, "contains itself"},
+		{"channel", make(chan int), "Cannot convert chan int"},
+		{"map with struct keys", map[point]int{point{}: 1}, "Cannot convert map with golsp.point keys"},
+	}
+
+	for _, c := range cases {
+		_, err := FromGo(c.value)
+		if err == nil {
+			t.Errorf("%s: expected an error", c.name)
+		} else if !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("%s: expected error containing %q, got %q", c.name, c.expected, err)
+		}
+	}
+}
+
+func TestToGo(t *testing.T) {
+	list := Object{Type: ObjectTypeList}
+	list.Elements.Append(NumberObject(1))
+	list.Elements.Append(NumberObject(2))
+	numbers := Object{
+		Type: ObjectTypeMap,
+		Map: map[string]Object{"1": StringObject("one")},
+		MapKeys: []Object{NumberObject(1)},
+	}
+	fields := MapObject(map[string]Object{
+		"X": NumberObject(1),
+		"y": NumberObject(2),
+		"Skip": NumberObject(3),
+	})
+
+	cases := []struct {
+		name string
+		obj Object
+		target interface{}
+		expected interface{}
+	}{
+		{"int", NumberObject(3), new(int), 3},
+		{"float", NumberObject(1.5), new(float64), 1.5},
+		{"string", StringObject("hi"), new(string), "hi"},
+		{"bool", NumberObject(0), new(bool), false},
+		{"undefined", UndefinedObject(), new(int), 0},
+		{"undefined pointer", UndefinedObject(), new(*int), (*int)(nil)},
+		{"pointer", NumberObject(7), new(*int), func () *int { x := 7; return &x }()},
+		{"slice", list, new([]int), []int{1, 2}},
+		{"array", list, new([2]float64), [2]float64{1, 2}},
+		{"string keys", MapObject(map[string]Object{"a": NumberObject(1)}), new(map[string]int), map[string]int{"a": 1}},
+		{"number keys", numbers, new(map[int]string), map[int]string{1: "one"}},
+		{"struct", fields, new(point), point{X: 1, Y: 2}},
+		{"error", ErrorObject("bad value"), new(error), errors.New("bad value")},
+		{"interface list", list, new(interface{}), []interface{}{1.0, 2.0}},
+		{"object", StringObject("kept"), new(Object), StringObject("kept")},
+	}
+
+	for _, c := range cases {
+		if err := ToGo(c.obj, c.target); err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+			continue
+		}
+		value := reflect.ValueOf(c.target).Elem().Interface()
+		if !reflect.DeepEqual(value, c.expected) {
+			t.Errorf("%s: expected %#v, got %#v", c.name, c.expected, value)
+		}
+	}
+}
+
+func TestToGoFunction(t *testing.T) {
+	fn, _ := FromGo(double)
+	var call func (int) (int, error)
+	if err := ToGo(fn, &call); err != nil { t.Fatalf("unexpected error: %v", err) }
+
+	result, err := call(21)
+	if err != nil { t.Fatalf("unexpected error: %v", err) }
+	if result != 42 { t.Errorf("expected 42, got %d", result) }
+}
+
+func TestToGoMismatch(t *testing.T) {
+	list := Object{Type: ObjectTypeList}
+	list.Elements.Append(StringObject("a"))
+
+	cases := []struct {
+		name string
+		obj Object
+		target interface{}
+		expected string
+	}{
+		{"non-pointer target", NumberObject(1), 1, "Cannot convert object to non-pointer target"},
+		{"string to int", StringObject("a"), new(int), "Cannot convert string to int"},
+		{"fraction to int", NumberObject(1.5), new(int), "Cannot convert 1.5 to int"},
+		{"negative to uint", NumberObject(-1), new(uint), "Cannot convert -1 to uint"},
+		{"overflow", NumberObject(300), new(int8), "Cannot convert 300 to int8"},
+		{"number to slice", NumberObject(1), new([]int), "Cannot convert number to []int"},
+		{"array length", list, new([2]string), "Cannot convert list of length 1 to [2]string"},
+		{"list elements", list, new([]int), "Cannot convert string to int"},
+		{"list to map", list, new(map[string]int), "Cannot convert list to map[string]int"},
+		{"struct field", MapObject(map[string]Object{"X": StringObject("a")}), new(point), "X: Cannot convert string to int"},
+		{"number to error", NumberObject(1), new(error), "Cannot convert number to error"},
+		{"number to function", NumberObject(1), new(func ()), "Cannot convert number to func()"},
+	}
+
+	for _, c := range cases {
+		err := ToGo(c.obj, c.target)
+		if err == nil {
+			t.Errorf("%s: expected an error", c.name)
+		} else if err.Error() != c.expected {
+			t.Errorf("%s: expected error %q, got %q", c.name, c.expected, err)
+		}
+	}
+}
@@ -7,10 +7,11 @@ import (
 	"fmt"
 	"strconv"
 	"errors"
+	"sort"
 )
 
 // STNode: A single syntax tree node that has a 'head' (i.e value), type,
-// list of child nodes and flags/fields for operators
+// list of child nodes, flags/fields for operators and the line on which it begins
 
 type STNodeType int
 const (
@@ -31,17 +32,20 @@ type STNode struct {
 	Spread bool
 	Zip *STNode
 	Dot *STNode
+	Line int
 }
 
 // /STNode
 
-// Scope: A 'scope' struct that has a parent scope and a map of strings
-// to objects
+// Scope: A 'scope' struct that has a parent scope, a map of strings
+// to objects and the interpreter that it belongs to
 
 type Scope struct {
 	Parent *Scope
 	Identifiers map[string]Object
 	Constants map[string]bool
+	interp *Interpreter
+	depth int
 }
 
 // /Scope
@@ -63,8 +67,8 @@ type Function struct {
 
 // Object: A container for basic values that wraps literals, functions,
 // lists and maps. Contains a type, value (for literals), function struct
-// (for functions), list of elements (for lists) and map (for maps). The scope property
-// is the scope in which the object was created, primarily used to create closures
+// (for functions), list of elements (for lists) and map (for maps and errors). The scope
+// property is the scope in which the object was created, primarily used to create closures
 
 type ObjectType int
 const (
@@ -73,6 +77,7 @@ const (
 	ObjectTypeFunction ObjectType = 1
 	ObjectTypeList ObjectType = 2
 	ObjectTypeMap ObjectType = 3
+	ObjectTypeError ObjectType = 4
 )
 
 type Object struct {
@@ -147,26 +152,42 @@ func NumberObject(num float64) Object {
 
 // MapObject: Produce a map object from a map of strings
 // to Objects. This function cannot produce maps that bind numbers
-// to objects
+// to objects (see 'FromGo')
 // `gomap`: the map
-// this function returns the produced Object
+// this function returns the produced Object, whose keys are sorted so that it
+// is always printed the same way
 func MapObject(gomap map[string]Object) Object {
 	object := Object{
 		Type: ObjectTypeMap,
 		Map: make(map[string]Object),
 		MapKeys: make([]Object, 0, len(gomap)),
 	}
-	for k, v := range gomap {
+	keys := make([]string, 0, len(gomap))
+	for k := range gomap { keys = append(keys, k) }
+	sort.Strings(keys)
+	for _, k := range keys {
 		strobj := StringObject(k)
-		object.Map[strobj.Value.Head] = v
+		object.Map[strobj.Value.Head] = gomap[k]
 		object.MapKeys = append(object.MapKeys, strobj)
 	}
 
 	return object
 }
 
+// ErrorObject: Produce an error object from a message. Errors are maps with
+// a "message" key -- Eval adds "file" and "line" keys to errors that are produced by
+// builtin functions
+// `message`: the error message
+// this function returns the produced Object
+func ErrorObject(message string) Object {
+	object := MapObject(map[string]Object{"message": StringObject(message)})
+	object.Type = ObjectTypeError
+
+	return object
+}
+
 // ListObject: Produce a list object from a slice of strings.
-// This function cannot produce lists that contain non-string objects
+// This function cannot produce lists that contain non-string objects (see 'FromGo')
 // `slice`: the slice
 // this function returns the produced Object
 func ListObject(slice []string) Object {
@@ -209,6 +230,14 @@ func ToNumber(obj Object) (float64, error) {
 	return strconv.ParseFloat(obj.Value.Head, 64)
 }
 
+// ToBoolean: Convert an object to a boolean, the same way the builtin
+// 'if' and 'when' functions do
+// `obj`: the object
+// this function returns true or false depending on the type and contents of obj
+func ToBoolean(obj Object) bool {
+	return objectToBoolean(obj)
+}
+
 // /Object de-constructors
 
 // names of special builtin identifiers
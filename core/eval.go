@@ -5,15 +5,9 @@ package golsp
 
 import (
 	"fmt"
-	"sync"
+	"strings"
 )
 
-// runtimeWaitGroup is the wait group used to wait for 'go' blocks to complete
-var runtimeWaitGroup sync.WaitGroup
-func RuntimeWaitGroup() *sync.WaitGroup {
-	return &runtimeWaitGroup
-}
-
 // comparePatternNode: Compare a node in a function pattern with an argument object
 // `pattern`: the pattern node
 // `arg`: the argument to compare with the pattern
@@ -142,6 +136,8 @@ func MakeScope(parent *Scope) Scope {
 		Parent: parent,
 		Identifiers: make(map[string]Object),
 		Constants: make(map[string]bool, len(parent.Constants)),
+		interp: parent.interp,
+		depth: parent.depth,
 	}
 	for k, v := range parent.Constants { newscope.Constants[k] = v }
 
@@ -180,6 +176,7 @@ func CopyObject(object Object) Object {
 			Parent: object.Scope.Parent,
 			Identifiers: make(map[string]Object, len(object.Scope.Identifiers)),
 			Constants: make(map[string]bool, len(object.Scope.Constants)),
+			interp: object.Scope.interp,
 		},
 	}
 
@@ -200,6 +197,8 @@ func IsolateScope(scope Scope) Scope {
 	newscope := Scope{
 		Identifiers: make(map[string]Object, len(scope.Identifiers)),
 		Constants: make(map[string]bool, len(scope.Constants)),
+		interp: scope.interp,
+		depth: scope.depth,
 	}
 	if scope.Parent != nil {
 		parent := IsolateScope(*(scope.Parent))
@@ -348,7 +347,9 @@ func SpreadNode(scope Scope, node STNode) List {
 	list := List{}
 	if obj.Value.Head == UNDEFINED { return list }
 
-	if obj.Type == ObjectTypeFunction || obj.Value.Type == STNodeTypeNumberLiteral {
+	// errors spread to themselves, so that they are not lost
+	if obj.Type == ObjectTypeFunction || obj.Type == ObjectTypeError ||
+		obj.Value.Type == STNodeTypeNumberLiteral {
 		list.Append(obj)
 		return list
 	}
@@ -366,14 +367,67 @@ func SpreadNode(scope Scope, node STNode) List {
 	return list
 }
 
+// callFrame: Produce a new activation frame for a call to a function. Every
+// call binds its arguments in a frame of its own, so concurrent calls to the same
+// function do not share any state
+// `fnobj`: the function object that is being called
+// this function returns the new frame, whose Parent is the scope in which the
+// function was defined
+func callFrame(fnobj Object) Scope {
+	frame := Scope{
+		Parent: fnobj.Scope.Parent,
+		Identifiers: make(map[string]Object),
+		Constants: make(map[string]bool, len(fnobj.Scope.Constants)),
+		interp: fnobj.Scope.interp,
+	}
+	for k, v := range fnobj.Scope.Constants { frame.Constants[k] = v }
+
+	return frame
+}
+
+// enterFrame: Check whether a call to a user-defined function is too deeply nested
+// (see 'MaxDepth'), and write it to the interpreter's trace (see 'Trace')
+// `frame`: the activation frame of the call (see 'callFrame')
+// `caller`: the scope in which the call is made
+// `line`: the line on which the call is made, or -1 if it is not made by an expression
+// `name`: the name of the function
+// `args`: the arguments of the call
+// this function returns an error object and false if the call is too deeply nested
+func enterFrame(frame *Scope, caller Scope, line int, name string, args List) (Object, bool) {
+	depth := caller.depth + 1
+	frame.depth = depth
+	interp := frame.interp
+	if interp == nil { return Object{}, true }
+	if interp.MaxDepth > 0 && depth > interp.MaxDepth {
+		return ErrorObject(fmt.Sprintf("maximum call depth (%d) exceeded in %s",
+			interp.MaxDepth, name)), false
+	}
+
+	if interp.Trace != nil {
+		strs := make([]string, 0, args.Length + 1)
+		if name == "" { name = "<lambda>" }
+		strs = append(strs, name)
+		for _, arg := range args.ToSlice() { strs = append(strs, Format(arg)) }
+		location := ""
+		if line >= 0 {
+			filename, _ := ToString(LookupIdentifier(caller, FILENAME))
+			location = fmt.Sprintf("%s:%d: ", filename, line)
+		}
+		fmt.Fprintf(interp.Trace, "%s%s[%s]\n",
+			location, strings.Repeat("  ", depth - 1), strings.Join(strs, " "))
+	}
+
+	return Object{}, true
+}
+
 // bindArguments: Bind the arguments passed to a function to the function
-// object's Scope property
-// `exprhead`: the 'expression head' i.e function object
+// call's activation frame
+// `frame`: the activation frame (see 'callFrame')
 // `pattern`: the matched pattern, based on which arguments will be bound
 // to identifiers
 // `argobjects`: the arguments passed to the function that will be bound to
 // identifiers
-func bindArguments(exprhead Object, pattern []STNode, argobjects List) {
+func bindArguments(frame Scope, pattern []STNode, argobjects List) {
 	currentarg := argobjects.First
 	for i := 0; i < len(pattern); currentarg, i = argobjects.Next(currentarg, i), i + 1 {
 		symbol := pattern[i]
@@ -383,18 +437,18 @@ func bindArguments(exprhead Object, pattern []STNode, argobjects List) {
 
 		if symbol.Type == STNodeTypeIdentifier {
 			if symbol.Spread {
-				exprhead.Scope.Identifiers[symbol.Head] = Object{
+				frame.Identifiers[symbol.Head] = Object{
 					Type: ObjectTypeList,
 					Elements: argobjects.sublist(currentarg, i),
 				}
 				break
 			}
-			exprhead.Scope.Identifiers[symbol.Head] = currentarg.Object
+			frame.Identifiers[symbol.Head] = currentarg.Object
 			continue
 		}
 
 		if currentarg.Object.Type == ObjectTypeList && symbol.Type == STNodeTypeList {
-			bindArguments(exprhead, symbol.Children, currentarg.Object.Elements)
+			bindArguments(frame, symbol.Children, currentarg.Object.Elements)
 		}
 
 		if currentarg.Object.Type == ObjectTypeMap && symbol.Type == STNodeTypeMap {
@@ -411,7 +465,7 @@ func bindArguments(exprhead Object, pattern []STNode, argobjects List) {
 				value, exists := currentarg.Object.Map[child.Head]
 				if !exists { continue }
 
-				bindArguments(exprhead, []STNode{*child.Zip}, ListFromSlice([]Object{value}))
+				bindArguments(frame, []STNode{*child.Zip}, ListFromSlice([]Object{value}))
 				mapped[child.Head] = true
 			}
 
@@ -433,8 +487,8 @@ func bindArguments(exprhead Object, pattern []STNode, argobjects List) {
 				patternvalues = append(patternvalues, *c.Zip)
 			}
 
-			bindArguments(exprhead, patternkeys, keys)
-			bindArguments(exprhead, patternvalues, values)
+			bindArguments(frame, patternkeys, keys)
+			bindArguments(frame, patternvalues, values)
 		}
 	}
 }
@@ -445,7 +499,7 @@ func bindArguments(exprhead Object, pattern []STNode, argobjects List) {
 // this function returns the value from the map object
 func evalDot(obj Object, root STNode) Object {
 	if root.Dot == nil { return obj }
-	if obj.Type != ObjectTypeMap { return UndefinedObject() }
+	if obj.Type != ObjectTypeMap && obj.Type != ObjectTypeError { return UndefinedObject() }
 	if root.Dot.Type != STNodeTypeIdentifier { return UndefinedObject() }
 
 	key := fmt.Sprintf("\"%s\"", root.Dot.Head)
@@ -455,6 +509,26 @@ func evalDot(obj Object, root STNode) Object {
 	return evalDot(value, *root.Dot)
 }
 
+// locateError: Record where an error was produced
+// `scope`: the scope in which the error was produced
+// `root`: the syntax tree node that produced the error
+// `obj`: the error object
+// this function returns a copy of the error with "file" and "line" keys, or obj
+// if it is not an error or already has a location
+func locateError(scope Scope, root STNode, obj Object) Object {
+	if obj.Type != ObjectTypeError { return obj }
+
+	filekey, linekey := StringObject("file"), StringObject("line")
+	if _, exists := obj.Map[linekey.Value.Head]; exists { return obj }
+
+	located := CopyObject(obj)
+	located.Map[filekey.Value.Head] = LookupIdentifier(scope, FILENAME)
+	located.Map[linekey.Value.Head] = NumberObject(float64(root.Line))
+	located.MapKeys = append(located.MapKeys, filekey, linekey)
+
+	return located
+}
+
 // CallFunction: call a function object with a list of arguments
 // `fnobj`: the function object
 // `args`: the list of arguments
@@ -462,7 +536,14 @@ func evalDot(obj Object, root STNode) Object {
 func CallFunction(fnobj Object, args List) Object {
 	if fnobj.Type != ObjectTypeFunction { return UndefinedObject() }
 
-	fnobj.Scope.Identifiers = make(map[string]Object, len(fnobj.Scope.Identifiers))
+	// builtin functions accept evaluated objects in place of syntax tree nodes,
+	// and are called with the scope of the interpreter that they are bound to.
+	// The functions that they return (i.e the methods of futures) are bound to it too
+	if fnobj.Function.BuiltinFunc != nil {
+		result, _ := bindBuiltins(fnobj.Function.BuiltinFunc(fnobj.Scope, args.ToSlice()), fnobj.Scope.interp)
+		return result
+	}
+
 	patternindex, patternfound := matchPatterns(fnobj.Function, args)
 	if !patternfound { return UndefinedObject() }
 
@@ -470,9 +551,13 @@ func CallFunction(fnobj Object, args List) Object {
 	body := fnobj.Function.FunctionBodies[patternindex]
 	if args.Length < len(pattern) { return UndefinedObject() }
 
-	bindArguments(fnobj, pattern, args)
+	frame := callFrame(fnobj)
+	if err, ok := enterFrame(&frame, fnobj.Scope, -1, fnobj.Function.Name, args); !ok {
+		return err
+	}
+	bindArguments(frame, pattern, args)
 
-	return Eval(fnobj.Scope, body)
+	return Eval(frame, body)
 }
 
 // Eval: Evaluate a syntax tree node within a scope
@@ -602,12 +687,6 @@ func Eval(scope Scope, root STNode) Object {
 		exprhead = Eval(MakeScope(&scope), root.Children[0])
 	}
 
-	// the function's argument scope is cleared every time it is called
-	// since the arguments will be bound again
-	if exprhead.Type == ObjectTypeFunction {
-		exprhead.Scope.Identifiers = make(map[string]Object, len(exprhead.Scope.Identifiers))
-	}
-
 	// evaluating an expression with a number literal or UNDEFINED head
 	// produces the literal or UNDEFINED
 	// i.e [1 2 3] evals to 1, [undefined a b c] evals to undefined
@@ -621,6 +700,7 @@ func Eval(scope Scope, root STNode) Object {
 	// if it is a map, lookup key
 	if exprhead.Type == ObjectTypeList ||
 		exprhead.Type == ObjectTypeMap ||
+		exprhead.Type == ObjectTypeError ||
 		exprhead.Value.Type == STNodeTypeStringLiteral {
 		for _, c := range root.Children[1:] {
 			if c.Spread {
@@ -630,7 +710,7 @@ func Eval(scope Scope, root STNode) Object {
 			}
 		}
 
-		if exprhead.Type == ObjectTypeMap {
+		if exprhead.Type == ObjectTypeMap || exprhead.Type == ObjectTypeError {
 			return evalDot(EvalMap(exprhead, argobjects), root)
 		}
 
@@ -653,7 +733,11 @@ func Eval(scope Scope, root STNode) Object {
 			argobjects.Append(obj)
 		}
 
-		return evalDot(fn.BuiltinFunc(scope, argobjects.ToSlice()), root)
+		result := locateError(scope, root, fn.BuiltinFunc(scope, argobjects.ToSlice()))
+		// functions created by the builtin (i.e the methods of futures) belong to
+		// the caller's interpreter
+		result, _ = bindBuiltins(result, scope.interp)
+		return evalDot(result, root)
 	}
 
 	// at this point the expression must be a calling a user-defined function
@@ -676,21 +760,25 @@ func Eval(scope Scope, root STNode) Object {
 		return UndefinedObject()
 	}
 
-	bindArguments(exprhead, pattern, argobjects)
+	frame := callFrame(exprhead)
+	if err, ok := enterFrame(&frame, scope, root.Line, fn.Name, argobjects); !ok {
+		return locateError(scope, root, err)
+	}
+	bindArguments(frame, pattern, argobjects)
 
-	return evalDot(Eval(exprhead.Scope, fn.FunctionBodies[patternindex]), root)
+	return evalDot(Eval(frame, fn.FunctionBodies[patternindex]), root)
 }
 
-// Run: Run a Golsp program
+// Run: Run a Golsp program with a new interpreter (see 'Interpreter.Run').
+// The interpreter runs the program's exit hooks when it is interrupted or terminated
 // `program`: the program to run
 // `dirname`: the directory of the program file
 // `filename`: the name of the program file
 // `args`: command line arguments passed to the program
 // this function returns the result of running the program
 func Run(dirname string, filename string, args []string, program string) Object {
-	InitializeBuiltins(dirname, filename, args)
-	result := Eval(Builtins, MakeST(Tokenize(program)))
-	defer RuntimeWaitGroup().Wait()
+	interp := NewInterpreter()
+	interp.HandleSignals = true
 
-	return result
+	return interp.Run(dirname, filename, args, program)
 }
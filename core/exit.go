@@ -0,0 +1,90 @@
+
+// Exit hooks
+
+package golsp
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// exitHook: a function call that is registered with 'atexit'. Exit hooks are
+// run in the reverse of the order in which they are registered
+type exitHook struct {
+	fn Object
+	args List
+}
+
+// BuiltinAtexit: The builtin 'atexit' function. This function registers a
+// function call that is made when the program exits -- normally, through 'os.exit',
+// or when it is interrupted or terminated by a signal, i.e
+// `[atexit printf "goodbye\n"]`. Exit hooks are called in the reverse of the order
+// in which they are registered
+// this function returns the function
+func BuiltinAtexit(scope Scope, args []Object) Object {
+	arguments := EvalArgs(scope, args)
+	if len(arguments) < 1 || arguments[0].Type != ObjectTypeFunction {
+		return ErrorObject("atexit requires a function")
+	}
+
+	hook := exitHook{
+		fn: IsolateFunction(arguments[0]),
+		args: ListFromSlice(arguments[1:]),
+	}
+
+	interp := scope.Interpreter()
+	interp.exitHooksMutex.Lock()
+	defer interp.exitHooksMutex.Unlock()
+	interp.exitHooks = append(interp.exitHooks, hook)
+
+	return arguments[0]
+}
+
+// RunExitHooks: Call every registered exit hook, most recently registered first.
+// Each hook is only called once, even if a hook exits the program itself
+func (i *Interpreter) RunExitHooks() {
+	for {
+		i.exitHooksMutex.Lock()
+		if len(i.exitHooks) == 0 {
+			i.exitHooksMutex.Unlock()
+			return
+		}
+		hook := i.exitHooks[len(i.exitHooks) - 1]
+		i.exitHooks = i.exitHooks[:len(i.exitHooks) - 1]
+		i.exitHooksMutex.Unlock()
+
+		Protect(func () Object { return CallFunction(hook.fn, hook.args) })
+	}
+}
+
+// Exit: Run the exit hooks and exit the program (by calling ExitFunc).
+// Native modules should exit through this function instead of 'os.Exit'
+// `code`: the exit status
+func (i *Interpreter) Exit(code int) {
+	i.RunExitHooks()
+	i.releaseValues()
+	i.ExitFunc(code)
+}
+
+// handleSignals: Run the exit hooks when the program is interrupted or
+// terminated, and then exit with the conventional status for the signal
+// this function returns a function that stops handling signals
+func (i *Interpreter) handleSignals() func() {
+	signals := make(chan os.Signal, 1)
+	stop := make(chan struct{})
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	go func () {
+		select {
+		case sig := <-signals:
+			i.Exit(128 + int(sig.(syscall.Signal)))
+		case <-stop:
+		}
+	}()
+
+	return func () {
+		signal.Stop(signals)
+		close(stop)
+	}
+}
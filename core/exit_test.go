@@ -0,0 +1,48 @@
+package golsp
+
+import (
+	"bufio"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSignalRunsExitHooks(t *testing.T) {
+	reader, writer := io.Pipe()
+	lines := bufio.NewScanner(reader)
+	status := make(chan int, 1)
+
+	interp := NewInterpreter()
+	interp.Stdout = writer
+	interp.HandleSignals = true
+	interp.ExitFunc = func (code int) { status <- code }
+
+	program := `[atexit printf "second hook\n"]
+[atexit printf "first hook\n"]
+printf "ready\n"
+sleep 500
+`
+	go func () {
+		interp.Run(".", "signal.golsp", nil, program)
+		writer.Close()
+	}()
+
+	// the signal is handled once the program starts, so it can only be sent then
+	if !lines.Scan() || lines.Text() != "ready" { t.Fatalf("expected ready, got %q", lines.Text()) }
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGINT); err != nil { t.Fatal(err) }
+
+	for _, expected := range []string{"first hook", "second hook"} {
+		if !lines.Scan() || lines.Text() != expected {
+			t.Fatalf("expected %q, got %q", expected, lines.Text())
+		}
+	}
+	select {
+	case code := <-status:
+		if code != 130 { t.Errorf("expected status 130, got %d", code) }
+	case <-time.After(time.Second):
+		t.Fatal("the program did not exit")
+	}
+	// the hooks only run once, so nothing else is printed when the program ends
+	if lines.Scan() { t.Errorf("unexpected output %q", lines.Text()) }
+}
@@ -0,0 +1,345 @@
+
+// Formatter
+
+package golsp
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// FORMAT_INDENT is the indentation of each level of a formatted program
+const FORMAT_INDENT = "  "
+
+// layoutNode: a node of a syntax tree that keeps the layout of the program that it
+// was parsed from -- its comments, its line breaks and its blank lines. Unlike
+// STNode, the text of literals is kept as it was written
+type layoutNode struct {
+	// text is the token of an identifier or literal, or the opening delimiter of a group
+	text string
+	group bool
+	children []*layoutNode
+	spread bool
+	// operators are the zip and dot operators that follow the node and their
+	// operands, in order, i.e `.b` and `: c` in `a.b: c`
+	operators []layoutOperator
+
+	// newline is whether the node begins a new line in its group
+	newline bool
+	// blank is whether a blank line precedes the node
+	blank bool
+	// comments are the comments on their own lines before the node
+	comments []layoutComment
+	// trailing is the comment at the end of the node's line
+	trailing string
+	// footer is the comments of a group after its last child
+	footer []layoutComment
+}
+
+type layoutOperator struct {
+	operator string
+	operand *layoutNode
+}
+
+type layoutComment struct {
+	text string
+	blank bool
+}
+
+// parseLayout: construct a layout tree from a list of tokens, following the same
+// rules as 'makeST' -- lines with more than one node are wrapped in an expression
+// unless they are the first line of a group or are in a list or map
+// `delim`: the leading delimiter of the current group
+// `tokens`: remaining tokens to parse
+// this function returns the group and a list of remaining unparsed tokens
+func parseLayout(delim string, tokens []string) (*layoutNode, []string) {
+	group := &layoutNode{text: delim, group: true}
+	delimtype := TokenDelimiterTypes[delim]
+	var last, prev *layoutNode
+	operator := ""
+	newline := false
+	linestart := 0
+	newlines := 0
+	comments := []layoutComment{}
+	trailing := ""
+
+	appendNode := func (node *layoutNode) {
+		node.newline = newlines > 0
+		node.blank = newlines > 1
+		node.comments = comments
+		comments = []layoutComment{}
+		newlines = 0
+
+		if operator != "" && last != nil {
+			last.operators = append(last.operators, layoutOperator{operator, node})
+			prev = node
+		} else {
+			group.children = append(group.children, node)
+			last, prev = node, node
+		}
+		operator = ""
+	}
+
+	for len(tokens) > 0 {
+		token := tokens[0]
+		tokens = tokens[1:]
+		if token == TokenDelimiters[delim] {
+			group.footer = comments
+			return group, tokens
+		}
+
+		if token == "\n" {
+			if newline && len(group.children) - linestart > 1 &&
+				delimtype != STNodeTypeMap && delimtype != STNodeTypeList {
+				line := group.children[linestart:]
+				node := &layoutNode{
+					text: "[",
+					group: true,
+					children: append([]*layoutNode{}, line...),
+					newline: line[0].newline,
+					blank: line[0].blank,
+					comments: line[0].comments,
+				}
+				line[0].newline, line[0].blank, line[0].comments = false, false, nil
+				group.children = append(group.children[:linestart], node)
+				last, prev = node, node
+			}
+			if trailing != "" && len(group.children) > 0 {
+				group.children[len(group.children) - 1].trailing = trailing
+			}
+			trailing = ""
+			newline = true
+			linestart = len(group.children)
+			newlines++
+			continue
+		}
+
+		if strings.HasPrefix(token, "#") {
+			text := strings.TrimRightFunc(token, unicode.IsSpace)
+			if len(group.children) > linestart {
+				trailing = text
+			} else {
+				comments = append(comments, layoutComment{text, newlines > 1})
+			}
+			newlines = 0
+			continue
+		}
+
+		if _, isDelimiter := TokenDelimiterTypes[token]; isDelimiter {
+			var node *layoutNode
+			node, tokens = parseLayout(token, tokens)
+			appendNode(node)
+			continue
+		}
+
+		if optype, isOperator := OperatorTypes[token]; isOperator && len(group.children) > 0 {
+			if optype == OperatorTypeSpread {
+				prev.spread = true
+			} else {
+				operator = token
+			}
+			continue
+		}
+
+		appendNode(&layoutNode{text: token})
+	}
+
+	group.footer = comments
+	return group, tokens
+}
+
+// multiline: check whether a group must be printed on more than one line, i.e it
+// has comments or its children do not all begin on the same line
+func (node *layoutNode) multiline() bool {
+	if len(node.footer) > 0 { return true }
+	for _, child := range node.children {
+		if child.newline || len(child.comments) > 0 || child.trailing != "" { return true }
+	}
+
+	return false
+}
+
+// unbracketed: check whether an expression can be printed without its brackets
+// when it is on a line of its own, so that the line is wrapped instead
+func (node *layoutNode) unbracketed() bool {
+	if !node.group || node.text != "[" || len(node.children) < 2 { return false }
+	if node.spread || len(node.operators) > 0 || node.multiline() { return false }
+	_, isOperator := OperatorTypes[node.children[0].text]
+
+	return node.children[0].group || !isOperator
+}
+
+// layoutPrinter: prints a layout tree as a canonical program
+type layoutPrinter struct {
+	builder strings.Builder
+}
+
+func (p *layoutPrinter) newline(depth int, blank bool) {
+	if blank { p.builder.WriteString("\n") }
+	p.builder.WriteString("\n" + strings.Repeat(FORMAT_INDENT, depth))
+}
+
+// comments: print comments on their own lines
+// `first`: whether the comments begin a block, so that blank lines before them are dropped
+// this function returns whether anything was printed
+func (p *layoutPrinter) comments(comments []layoutComment, depth int, first bool) bool {
+	for _, comment := range comments {
+		p.newline(depth, comment.blank && !first)
+		p.builder.WriteString(comment.text)
+		first = false
+	}
+
+	return len(comments) > 0
+}
+
+// term: print a node, followed by its operators
+func (p *layoutPrinter) term(node *layoutNode, depth int) {
+	if node.group {
+		p.group(node, depth)
+	} else {
+		p.builder.WriteString(node.text)
+	}
+	if node.spread { p.builder.WriteString("...") }
+
+	for _, op := range node.operators {
+		p.builder.WriteString(op.operator)
+		if op.operator == ":" { p.builder.WriteString(" ") }
+		p.term(op.operand, depth)
+	}
+}
+
+// terms: print nodes on one line, separated by spaces
+func (p *layoutPrinter) terms(nodes []*layoutNode, depth int) {
+	for i, node := range nodes {
+		if i > 0 { p.builder.WriteString(" ") }
+		p.term(node, depth)
+	}
+}
+
+// line: print a node on a line of its own, with its comments. Expressions are
+// printed without brackets where possible (see 'unbracketed')
+// `first`: whether the line begins a block, so that blank lines before it are dropped
+func (p *layoutPrinter) line(node *layoutNode, depth int, first bool) {
+	first = !p.comments(node.comments, depth, first) && first
+	p.newline(depth, node.blank && !first)
+	if node.unbracketed() {
+		p.terms(node.children, depth)
+	} else {
+		p.term(node, depth)
+	}
+	p.trail(node)
+}
+
+func (p *layoutPrinter) trail(node *layoutNode) {
+	if node.trailing != "" { p.builder.WriteString(" " + node.trailing) }
+}
+
+// group: print a group. Groups that fit on one line are printed as `[a b]`,
+// `{ a b }` and `( a: b )`. Otherwise the closing delimiter is on a line of its own,
+// and the lines of the group are indented:
+//   - expressions keep their first line after the opening bracket, and each of
+//     their other nodes is on a line of its own
+//   - lists keep the nodes of each line together, and maps have one node per line,
+//     beginning on the line after the opening delimiter
+func (p *layoutPrinter) group(node *layoutNode, depth int) {
+	closing := TokenDelimiters[node.text]
+	listlike := node.text != "["
+	p.builder.WriteString(node.text)
+
+	if !node.multiline() {
+		if listlike && len(node.children) > 0 { p.builder.WriteString(" ") }
+		p.terms(node.children, depth)
+		if listlike && len(node.children) > 0 { p.builder.WriteString(" ") }
+		p.builder.WriteString(closing)
+		return
+	}
+
+	rest := node.children
+	if !listlike {
+		head := 0
+		for head < len(rest) && !rest[head].newline { head++ }
+		p.terms(rest[:head], depth)
+		if head > 0 { p.trail(rest[head - 1]) }
+		rest = rest[head:]
+	}
+
+	first := true
+	for i := 0; i < len(rest); i++ {
+		if !listlike || node.text == "(" {
+			p.line(rest[i], depth + 1, first)
+			first = false
+			continue
+		}
+
+		// a line of a list
+		end := i + 1
+		for end < len(rest) && !rest[end].newline { end++ }
+		first = !p.comments(rest[i].comments, depth + 1, first) && first
+		p.newline(depth + 1, rest[i].blank && !first)
+		p.terms(rest[i:end], depth + 1)
+		p.trail(rest[end - 1])
+		first = false
+		i = end - 1
+	}
+
+	p.comments(node.footer, depth + 1, first)
+	p.newline(depth, false)
+	p.builder.WriteString(closing)
+}
+
+// sameST: check whether two syntax trees are the same, apart from their line numbers
+// and the heads of expressions (which are empty if a line was wrapped)
+func sameST(a *STNode, b *STNode) bool {
+	if a == nil || b == nil { return a == b }
+	if a.Type != STNodeTypeExpression && a.Head != b.Head { return false }
+	if a.Type != b.Type || a.Spread != b.Spread ||
+		len(a.Children) != len(b.Children) {
+		return false
+	}
+	for i := range a.Children {
+		if !sameST(&a.Children[i], &b.Children[i]) { return false }
+	}
+
+	return sameST(a.Zip, b.Zip) && sameST(a.Dot, b.Dot)
+}
+
+// countComments: count the comment tokens in a list of tokens
+func countComments(tokens []string) int {
+	count := 0
+	for _, token := range tokens {
+		if strings.HasPrefix(token, "#") { count++ }
+	}
+
+	return count
+}
+
+// FormatProgram: print a program in the canonical layout. Comments and blank lines
+// (at most one in a row) are kept, and each node that is on a line of its own is
+// printed without brackets where the line would be wrapped in an expression anyway,
+// i.e `def a 1` instead of `[def a 1]`
+// `program`: the program to format
+// this function returns the formatted program and an optional error -- a
+// SyntaxError if the program is invalid
+func FormatProgram(program string) (string, error) {
+	tokens := Tokenize(program)
+	if err := CheckSyntax(tokens); err != nil { return "", err }
+
+	root, _ := parseLayout(tokens[0], tokens[1:])
+	p := layoutPrinter{}
+	for i, child := range root.children {
+		p.line(child, 0, i == 0)
+	}
+	p.comments(root.footer, 0, len(root.children) == 0)
+	formatted := strings.TrimPrefix(p.builder.String(), "\n")
+	if formatted != "" { formatted += "\n" }
+
+	// formatting must not change what the program means
+	formattedtokens := Tokenize(formatted)
+	original, result := MakeST(tokens), MakeST(formattedtokens)
+	if !sameST(&original, &result) || countComments(tokens) != countComments(formattedtokens) {
+		return "", errors.New("cannot format program without changing its meaning")
+	}
+
+	return formatted, nil
+}
@@ -0,0 +1,369 @@
+
+// Futures
+
+package golsp
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// future: The state of a concurrently evaluated 'go' block. `done` is closed
+// once `result` has been set and `cancelled` is closed when the future is cancelled,
+// after which `onCancel` (if any) is called.
+// The future is exposed to Golsp programs as a map of builtin functions
+
+type future struct {
+	result Object
+	done chan struct{}
+	cancelled chan struct{}
+	cancelOnce sync.Once
+	onCancel func()
+}
+
+func newFuture() *future {
+	return &future{
+		done: make(chan struct{}),
+		cancelled: make(chan struct{}),
+	}
+}
+
+// /future
+
+// resolve: Set the result of a future and wake up everything that is waiting on it
+// `result`: the result of the future
+func (f *future) resolve(result Object) {
+	f.result = result
+	close(f.done)
+}
+
+// isDone: Check whether a future has been resolved
+// this function returns whether the future is resolved
+func (f *future) isDone() bool {
+	select {
+	case <-f.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// isCancelled: Check whether a future has been cancelled
+// this function returns whether the future is cancelled
+func (f *future) isCancelled() bool {
+	select {
+	case <-f.cancelled:
+		return true
+	default:
+		return false
+	}
+}
+
+// wait: The 'wait' method of a future. This function blocks until the future
+// is resolved or cancelled, or until an optional timeout (in milliseconds) expires
+// this function returns the result of the future, or UNDEFINED if it was cancelled
+// or timed out
+func (f *future) wait(scope Scope, args []Object) Object {
+	arguments := EvalArgs(scope, args)
+
+	var timeout <-chan time.Time
+	if len(arguments) > 0 {
+		ms, err := ToNumber(arguments[0])
+		if err != nil { return UndefinedObject() }
+		timer := time.NewTimer(time.Duration(ms * float64(time.Millisecond)))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	// a future that has already been resolved always produces its result
+	if f.isDone() { return f.result }
+
+	select {
+	case <-f.done:
+		return f.result
+	case <-f.cancelled:
+		return UndefinedObject()
+	case <-timeout:
+		return UndefinedObject()
+	}
+}
+
+// cancel: The 'cancel' method of a future. This function marks the future as
+// cancelled -- waiting on it stops immediately and the 'go' block can observe
+// the cancellation through 'cancelled?'
+// this function returns 1 if the future was still pending and 0 otherwise
+func (f *future) cancel(_ Scope, _ []Object) Object {
+	if f.isDone() || f.isCancelled() { return NumberObject(0) }
+
+	f.cancelOnce.Do(func () {
+		close(f.cancelled)
+		if f.onCancel != nil { f.onCancel() }
+	})
+	return NumberObject(1)
+}
+
+// object: Produce the Golsp representation of a future
+// this function returns a map containing the future's methods
+func (f *future) object() Object {
+	isDone := func (_ Scope, _ []Object) Object {
+		if f.isDone() || f.isCancelled() { return NumberObject(1) }
+		return NumberObject(0)
+	}
+
+	return MapObject(map[string]Object{
+		"wait": BuiltinFunctionObject("wait", f.wait),
+		"cancel": BuiltinFunctionObject("cancel", f.cancel),
+		"done?": BuiltinFunctionObject("done?", isDone),
+	})
+}
+
+// BuiltinGo: The builtin 'go' function. This function concurrently evaluates
+// a series of statements within an enclosed, isolated scope. The statements can
+// call 'cancelled?' to check whether the block has been cancelled. The program
+// does not exit until every 'go' block has completed
+// this function returns a future (see 'future')
+func BuiltinGo(scope Scope, arguments []Object) Object {
+	return goBlock(scope, arguments, true)
+}
+
+// BuiltinDetach: The builtin 'detach' function. This function is identical to
+// 'go', except that the program can exit before the block has completed
+// this function returns a future (see 'future')
+func BuiltinDetach(scope Scope, arguments []Object) Object {
+	return goBlock(scope, arguments, false)
+}
+
+// goBlock: Concurrently evaluate a series of statements (see 'BuiltinGo')
+// `scope`: the scope in which the block is evaluated
+// `arguments`: the statements in the block
+// `awaited`: whether the program waits for the block to complete before exiting
+// this function returns a future (see 'future')
+func goBlock(scope Scope, arguments []Object, awaited bool) Object {
+	fut := newFuture()
+	cancelled := func (_ Scope, _ []Object) Object {
+		if fut.isCancelled() { return NumberObject(1) }
+		return NumberObject(0)
+	}
+
+	// the scope is isolated before the block starts so that the block never
+	// reads scopes that are still being modified by the caller
+	isolated := IsolateScope(scope)
+	blockscope := MakeScope(&isolated)
+	blockscope.Identifiers["cancelled?"] = BuiltinFunctionObject("cancelled?", cancelled)
+
+	interp := scope.Interpreter()
+	if awaited { interp.waitGroup.Add(1) }
+	go func () {
+		if awaited { defer interp.waitGroup.Done() }
+		fut.resolve(Protect(func () Object { return BuiltinDo(blockscope, arguments) }))
+	}()
+
+	return fut.object()
+}
+
+// Protect: Call a function, recovering from any Go panic that occurs while it is
+// called. This keeps failures inside of 'go' blocks (i.e from builtin or plugin
+// functions) from crashing the entire program
+// `fn`: the function to call
+// this function returns the result of fn, or an error object if fn panicked
+func Protect(fn func() Object) (result Object) {
+	defer func () {
+		if r := recover(); r != nil {
+			result = ErrorObject(fmt.Sprintf("panic: %v", r))
+		}
+	}()
+
+	return fn()
+}
+
+// IsolateFunction: Give a function object its own isolated copy of the scope in
+// which it was defined (see 'IsolateScope'), so that it can be called from another
+// goroutine. This must be done before the function is passed to the goroutine
+// `fn`: the function object
+// this function returns the isolated copy of the function
+func IsolateFunction(fn Object) Object {
+	fncopy := CopyObject(fn)
+	if fn.Scope.Parent != nil {
+		isolated := IsolateScope(*fn.Scope.Parent)
+		fncopy.Scope.Parent = &isolated
+	}
+
+	return fncopy
+}
+
+// BuiltinSupervise: The builtin 'supervise' function. This function concurrently
+// calls a function with no arguments, i.e `[supervise worker retries backoff]`, and calls
+// it again whenever it fails (produces an error or panics), up to `retries` times
+// (3 by default). It waits `backoff` milliseconds (100 by default) before the first
+// restart, doubling the delay before each subsequent restart. Cancelling the future
+// stops the function from being called again, but does not interrupt a call that
+// is running
+// this function returns a future (see 'future') that resolves to the result of the
+// first successful call, or the error produced by the last call
+func BuiltinSupervise(scope Scope, args []Object) Object {
+	arguments := EvalArgs(scope, args)
+	if len(arguments) < 1 || arguments[0].Type != ObjectTypeFunction {
+		return ErrorObject("supervise requires a function")
+	}
+
+	settings := []float64{3, 100}
+	for i, arg := range arguments[1:] {
+		if i >= len(settings) { break }
+		n, err := ToNumber(arg)
+		if err != nil || n < 0 {
+			return ErrorObject("supervise retries and backoff must be non-negative numbers")
+		}
+		settings[i] = n
+	}
+	retries := int(settings[0])
+	backoff := time.Duration(settings[1] * float64(time.Millisecond))
+
+	worker := IsolateFunction(arguments[0])
+	fut := newFuture()
+
+	interp := scope.Interpreter()
+	interp.waitGroup.Add(1)
+	go func () {
+		defer interp.waitGroup.Done()
+
+		result := UndefinedObject()
+		for attempt := 0; attempt <= retries; attempt++ {
+			if attempt > 0 {
+				timer := time.NewTimer(backoff << uint(attempt - 1))
+				select {
+				case <-timer.C:
+				case <-fut.cancelled:
+					timer.Stop()
+				}
+			}
+			// the function is never called again once the future is cancelled,
+			// even if the backoff expired or the call that was running failed
+			if fut.isCancelled() { break }
+
+			result = Protect(func () Object { return CallFunction(worker, List{}) })
+			if result.Type != ObjectTypeError { break }
+		}
+
+		fut.resolve(result)
+	}()
+
+	return fut.object()
+}
+
+// BuiltinCancelled: The builtin 'cancelled?' function. 'go' blocks shadow this
+// function with one that reports whether the block has been cancelled -- outside
+// of 'go' blocks, nothing can be cancelled
+// this function returns 0
+func BuiltinCancelled(_ Scope, _ []Object) Object {
+	return NumberObject(0)
+}
+
+// futureMethod: Look up a method of a future
+// `obj`: the future object
+// `name`: the name of the method
+// this function returns the method and whether it exists
+func futureMethod(obj Object, name string) (Object, bool) {
+	if obj.Type != ObjectTypeMap { return UndefinedObject(), false }
+
+	method, exists := obj.Map[StringObject(name).Value.Head]
+	if !exists || method.Type != ObjectTypeFunction {
+		return UndefinedObject(), false
+	}
+
+	return method, true
+}
+
+// futureResult: a result produced by one of the futures passed to a combinator
+type futureResult struct {
+	index int
+	result Object
+}
+
+// combineFutures: Produce a builtin function that combines a number of futures
+// into a single future
+// `combine`: a function that receives the results of the futures in the order
+// they are resolved, along with the number of futures, and produces the result of
+// the combined future
+// this function returns the builtin combinator function. Cancelling the combined
+// future cancels all of the futures that were passed to it, and so does resolving
+// it -- the futures that are still pending at that point are cancelled
+func combineFutures(combine func(<-chan futureResult, int) Object) BuiltinFunction {
+	return func (scope Scope, args []Object) Object {
+		futures := EvalArgs(scope, args)
+		waits := make([]Object, len(futures))
+		for i, obj := range futures {
+			wait, exists := futureMethod(obj, "wait")
+			if !exists { return UndefinedObject() }
+			waits[i] = wait
+		}
+
+		results := make(chan futureResult, len(futures))
+		for i, wait := range waits {
+			go func (index int, wait Object) {
+				results <- futureResult{index, CallFunction(wait, List{})}
+			}(i, wait)
+		}
+
+		cancelAll := func () {
+			for _, obj := range futures {
+				cancel, exists := futureMethod(obj, "cancel")
+				if exists { CallFunction(cancel, List{}) }
+			}
+		}
+
+		fut := newFuture()
+		go func () {
+			result := make(chan Object, 1)
+			go func () { result <- combine(results, len(futures)) }()
+
+			select {
+			case r := <-result:
+				// the futures that are still pending (i.e the ones that lost a
+				// 'race') can no longer affect the result. They are cancelled first
+				// so that they are cancelled by the time that the result is seen
+				cancelAll()
+				fut.resolve(r)
+			case <-fut.cancelled:
+				cancelAll()
+			}
+		}()
+
+		return fut.object()
+	}
+}
+
+// BuiltinAll: The builtin 'all' function. This function combines futures
+// into a future that resolves to the list of all of their results, in the order
+// in which the futures were passed
+var BuiltinAll = combineFutures(func (results <-chan futureResult, n int) Object {
+	objects := make([]Object, n)
+	for i := 0; i < n; i++ {
+		r := <-results
+		objects[r.index] = r.result
+	}
+
+	return Object{
+		Type: ObjectTypeList,
+		Elements: ListFromSlice(objects),
+	}
+})
+
+// BuiltinAny: The builtin 'any' function. This function combines futures into
+// a future that resolves to the first result that is not UNDEFINED, or UNDEFINED
+// if none of the futures produce a value
+var BuiltinAny = combineFutures(func (results <-chan futureResult, n int) Object {
+	for i := 0; i < n; i++ {
+		r := <-results
+		if r.result.Value.Head != UNDEFINED { return r.result }
+	}
+
+	return UndefinedObject()
+})
+
+// BuiltinRace: The builtin 'race' function. This function combines futures into
+// a future that resolves to the result of whichever future completes first
+var BuiltinRace = combineFutures(func (results <-chan futureResult, n int) Object {
+	if n == 0 { return UndefinedObject() }
+	return (<-results).result
+})
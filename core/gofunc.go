@@ -0,0 +1,108 @@
+
+// Go functions as builtins
+
+package golsp
+
+import (
+	"fmt"
+	"reflect"
+)
+
+var scopeType = reflect.TypeOf(Scope{})
+
+// GoFunction: Produce a builtin function from an ordinary Go function, i.e
+// `func (path string, mode int) (int, error)`. Arguments are evaluated and converted
+// with 'ToGo' -- a wrong number of arguments or an argument that cannot be converted
+// produces an error object. A non-nil error returned as the last result of the Go
+// function becomes an error object, and the other results are converted with
+// 'FromGo': no results become UNDEFINED and multiple results become a list. If the
+// first parameter of the Go function is a Scope, it receives the scope of the call.
+// Variadic Go functions are variadic builtins
+// `name`: the name of the function, used in error messages
+// `fn`: the Go function. This function panics if it is not a function
+// this function returns the builtin function
+func GoFunction(name string, fn interface{}) BuiltinFunction {
+	value := reflect.ValueOf(fn)
+	if value.Kind() != reflect.Func {
+		panic(fmt.Sprintf("GoFunction: %s is a %T, not a function", name, fn))
+	}
+
+	return goFunction(name, value)
+}
+
+// GoFunctionObject: Produce a function object from an ordinary Go function
+// (see 'GoFunction')
+// `name`: the name of the function
+// `fn`: the Go function
+// this function returns the Object containing the builtin function
+func GoFunctionObject(name string, fn interface{}) Object {
+	return BuiltinFunctionObject(name, GoFunction(name, fn))
+}
+
+// Register: Bind an ordinary Go function to a constant in the interpreter's builtin
+// scope (see 'GoFunction'), making it available to every module
+// `name`: the name of the builtin
+// `fn`: the Go function
+func (i *Interpreter) Register(name string, fn interface{}) {
+	obj := GoFunctionObject(name, fn)
+	obj.Scope = Scope{interp: i}
+	i.Builtins.Identifiers[name] = obj
+	i.Builtins.Constants[name] = true
+}
+
+func goFunction(name string, fn reflect.Value) BuiltinFunction {
+	t := fn.Type()
+	returnsError := t.NumOut() > 0 && t.Out(t.NumOut() - 1) == errorType
+	first := 0
+	if t.NumIn() > 0 && t.In(0) == scopeType { first = 1 }
+	nparams := t.NumIn() - first
+	if t.IsVariadic() { nparams-- }
+
+	return func (scope Scope, args []Object) Object {
+		arguments := EvalArgs(scope, args)
+		if len(arguments) < nparams || (!t.IsVariadic() && len(arguments) > nparams) {
+			expected := fmt.Sprint(nparams)
+			if t.IsVariadic() { expected = "at least " + expected }
+			return ErrorObject(fmt.Sprintf("%s expects %s arguments, got %d",
+				name, expected, len(arguments)))
+		}
+
+		in := make([]reflect.Value, first + len(arguments))
+		if first > 0 { in[0] = reflect.ValueOf(scope) }
+		for i, arg := range arguments {
+			var paramtype reflect.Type
+			if t.IsVariadic() && i >= nparams {
+				paramtype = t.In(t.NumIn() - 1).Elem()
+			} else {
+				paramtype = t.In(first + i)
+			}
+
+			in[first + i] = reflect.New(paramtype).Elem()
+			if err := toValue(arg, in[first + i]); err != nil {
+				return ErrorObject(fmt.Sprintf("%s argument %d: %v", name, i + 1, err))
+			}
+		}
+
+		out := fn.Call(in)
+		if returnsError {
+			if err := out[len(out) - 1]; !err.IsNil() {
+				return ErrorObject(err.Interface().(error).Error())
+			}
+			out = out[:len(out) - 1]
+		}
+
+		results := make([]Object, len(out))
+		for i, value := range out {
+			result, err := fromValue(value)
+			if err != nil { return ErrorObject(fmt.Sprintf("%s result: %v", name, err)) }
+			results[i] = result
+		}
+
+		switch len(results) {
+		case 0: return UndefinedObject()
+		case 1: return results[0]
+		}
+
+		return Object{Type: ObjectTypeList, Elements: ListFromSlice(results)}
+	}
+}
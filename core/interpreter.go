@@ -0,0 +1,420 @@
+
+// Interpreter
+
+package golsp
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Interpreter: The state of a single Golsp program. An interpreter owns its
+// builtin scope, the wait group for its 'go' blocks and timers, its exit hooks,
+// the modules it has loaded and the streams that it reads from and writes to.
+// Any number of interpreters can be used in the same process
+
+type Interpreter struct {
+	// Builtins is the scope that contains the builtin identifiers,
+	// and is the parent of every module's scope
+	Builtins Scope
+
+	Stdin io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Stdlib is the filesystem that 'stdlib/' modules are loaded from, the
+	// registered standard library by default (see 'RegisterStdlib')
+	Stdlib fs.FS
+
+	// StdlibPath, if it is set, is a directory that 'stdlib/' modules are loaded
+	// from instead of Stdlib (i.e a source checkout), $GOLSPPATH by default
+	StdlibPath string
+
+	// SearchPath is the list of directories that bare module names are looked up
+	// in (see 'Resolve'), $GOLSPMODULES (a colon-separated list) by default
+	SearchPath []string
+
+	// HandleSignals is whether 'Run' runs the exit hooks and exits when the
+	// program is interrupted or terminated
+	HandleSignals bool
+
+	// ExitFunc is called by 'Exit' after the exit hooks have run, os.Exit by default
+	ExitFunc func(int)
+
+	// MaxDepth, if it is positive, is the number of nested calls to user-defined
+	// functions after which a call produces an error instead of being made
+	MaxDepth int
+
+	// Trace, if it is set, is where every call to a user-defined function is
+	// written, indented by its depth
+	Trace io.Writer
+
+	scope Scope
+	waitGroup sync.WaitGroup
+	exitHooks []exitHook
+	exitHooksMutex sync.Mutex
+	modules map[string]*module
+	modulesMutex sync.Mutex
+	values map[interface{}]interface{}
+	valuesMutex sync.Mutex
+}
+
+// NewInterpreter: Create an interpreter with the default settings
+// this function returns the new interpreter
+func NewInterpreter() *Interpreter {
+	interp := &Interpreter{
+		Stdin: os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+		StdlibPath: os.Getenv("GOLSPPATH"),
+		Stdlib: registeredStdlib(),
+		SearchPath: filepath.SplitList(os.Getenv("GOLSPMODULES")),
+		ExitFunc: os.Exit,
+		modules: make(map[string]*module),
+		values: make(map[interface{}]interface{}),
+	}
+	interp.initializeBuiltins()
+	interp.Reset()
+
+	return interp
+}
+
+// /Interpreter
+
+// Interpreter: Find the interpreter that a scope belongs to
+// this function returns the interpreter, or a new interpreter with the default
+// settings if the scope was not created by one (i.e the zero Scope)
+func (scope Scope) Interpreter() *Interpreter {
+	if scope.interp != nil { return scope.interp }
+
+	fallbackOnce.Do(func () { fallback = NewInterpreter() })
+	return fallback
+}
+
+// fallback is the interpreter used by scopes that do not belong to any interpreter
+var fallback *Interpreter
+var fallbackOnce sync.Once
+
+// moduleScope: Create the scope in which a module (i.e a file) is evaluated
+// `dirname`: the directory of the module file
+// `filename`: the name of the module file
+// `args`: command line arguments passed to the module
+// this function returns the module's scope, which descends from the builtin scope
+func (i *Interpreter) moduleScope(dirname string, filename string, args []string) Scope {
+	scope := MakeScope(&i.Builtins)
+	scope.Identifiers[DIRNAME] = StringObject(dirname)
+	scope.Identifiers[FILENAME] = StringObject(filename)
+	scope.Identifiers[ARGS] = ListObject(args)
+	for _, identifier := range []string{DIRNAME, FILENAME, ARGS} {
+		scope.Constants[identifier] = true
+	}
+
+	return scope
+}
+
+// readModule: Read a module file. Paths that begin with 'stdlib/' are read from
+// StdlibPath if it is set, or from the Stdlib filesystem
+// `path`: the resolved path of the module
+// this function returns the contents of the file and an optional error
+func (i *Interpreter) readModule(path string) ([]byte, error) {
+	if !strings.HasPrefix(path, STDLIB_PREFIX) { return os.ReadFile(path) }
+	if i.StdlibPath != "" { return os.ReadFile(filepath.Join(i.StdlibPath, path)) }
+	if i.Stdlib == nil { return nil, errors.New("no standard library is registered") }
+
+	return fs.ReadFile(i.Stdlib, strings.TrimPrefix(path, STDLIB_PREFIX))
+}
+
+// module: A module that has been, or is being, loaded by an interpreter.
+// `chain` is the chain of modules that required it (ending with the module itself),
+// `done` is closed once `result` is available and `exports` are the identifiers
+// that it exports from `scope` (see 'BuiltinExport')
+type module struct {
+	result Object
+	chain []string
+	done chan struct{}
+	exports []string
+	scope Scope
+}
+
+// beginModule: Mark a module as being loaded
+// `importer`: the file that requires the module, or "" if the module is the
+// program that is being run
+// `filename`: the resolved path of the module
+// this function returns the module and whether it is new. If it is not new, it
+// has already been loaded or is being loaded, and its chain is the import cycle that
+// requiring it would cause (if any)
+func (i *Interpreter) beginModule(importer string, filename string) (*module, bool) {
+	i.modulesMutex.Lock()
+	defer i.modulesMutex.Unlock()
+
+	chain := []string{}
+	if importer != "" { chain = []string{importer} }
+	if entry, exists := i.modules[importer]; exists { chain = entry.chain }
+	chain = append(chain[:len(chain):len(chain)], filename)
+
+	if entry, exists := i.modules[filename]; exists {
+		for index, path := range chain[:len(chain) - 1] {
+			if path == filename { return &module{chain: chain[index:]}, false }
+		}
+		return entry, false
+	}
+
+	entry := &module{chain: chain, done: make(chan struct{})}
+	i.modules[filename] = entry
+
+	return entry, true
+}
+
+// loadModule: Evaluate a module without waiting for its 'go' blocks to complete.
+// Modules are cached by their resolved path, so each module is only evaluated once --
+// requiring a module that is being loaded by another 'go' block waits for it, and
+// requiring a module that is being loaded by the requiring module itself (directly or
+// indirectly) produces an error that shows the import cycle
+// `importer`: the file that requires the module
+// `filename`: the resolved path of the module
+// this function returns the result of evaluating the module, or an error
+func (i *Interpreter) loadModule(importer string, filename string) Object {
+	entry, isnew := i.beginModule(importer, filename)
+	if !isnew {
+		if entry.done == nil {
+			return ErrorObject("import cycle: " + strings.Join(entry.chain, " -> "))
+		}
+		<-entry.done
+		return entry.result
+	}
+	defer close(entry.done)
+
+	data, err := i.readModule(filename)
+	if err != nil {
+		i.InvalidateModule(filename)
+		entry.result = UndefinedObject()
+		return entry.result
+	}
+
+	scope := i.moduleScope(filepath.Dir(filename), filename, []string{})
+	entry.result = i.moduleResult(entry, evalModule(scope, string(data)))
+
+	return entry.result
+}
+
+// export: Add an identifier to the exports of a module that is being loaded
+// `filename`: the resolved path of the module
+// `identifier`: the identifier
+// `scope`: the scope that the module's statements are evaluated in
+// this function returns whether the module is being loaded
+func (i *Interpreter) export(filename string, identifier string, scope Scope) bool {
+	i.modulesMutex.Lock()
+	defer i.modulesMutex.Unlock()
+
+	entry, exists := i.modules[filename]
+	if !exists { return false }
+	entry.scope = scope
+	for _, exported := range entry.exports {
+		if exported == identifier { return true }
+	}
+	entry.exports = append(entry.exports, identifier)
+
+	return true
+}
+
+// moduleResult: Find the object that a module exports -- a map of the identifiers
+// that it exports (in the order that they were exported) if it uses 'export', or
+// the result of its last statement
+// `entry`: the module
+// `result`: the result of the module's last statement
+// this function returns the exported object
+func (i *Interpreter) moduleResult(entry *module, result Object) Object {
+	i.modulesMutex.Lock()
+	exports, scope := entry.exports, entry.scope
+	i.modulesMutex.Unlock()
+	if len(exports) == 0 { return result }
+
+	object := MapObject(map[string]Object{})
+	for _, identifier := range exports {
+		key := StringObject(identifier)
+		object.Map[key.Value.Head] = LookupIdentifier(scope, identifier)
+		object.MapKeys = append(object.MapKeys, key)
+	}
+
+	return object
+}
+
+// InvalidateModule: Remove a module from the interpreter's module cache, so that
+// it is evaluated again the next time it is required
+// `filename`: the resolved path of the module, i.e an absolute path or a path that
+// begins with 'stdlib/'
+// this function returns whether the module was cached
+func (i *Interpreter) InvalidateModule(filename string) bool {
+	i.modulesMutex.Lock()
+	defer i.modulesMutex.Unlock()
+
+	_, exists := i.modules[filename]
+	delete(i.modules, filename)
+
+	return exists
+}
+
+// InvalidateModules: Clear the interpreter's module cache
+func (i *Interpreter) InvalidateModules() {
+	i.modulesMutex.Lock()
+	defer i.modulesMutex.Unlock()
+	i.modules = make(map[string]*module)
+}
+
+// Run: Run a Golsp program, wait for all of its 'go' blocks and timers and then
+// run its exit hooks (see 'BuiltinAtexit')
+// `dirname`: the directory of the program file
+// `filename`: the name of the program file
+// `args`: command line arguments passed to the program
+// `program`: the program to run
+// this function returns the result of running the program
+func (i *Interpreter) Run(dirname string, filename string, args []string, program string) Object {
+	if i.HandleSignals {
+		stop := i.handleSignals()
+		defer stop()
+	}
+
+	// the program is a module too, so that requiring it from one of its
+	// dependencies is detected as an import cycle
+	entry, isnew := i.beginModule("", filename)
+	scope := i.moduleScope(dirname, filename, args)
+	result := evalModule(scope, program)
+	if isnew {
+		result = i.moduleResult(entry, result)
+		entry.result = result
+		close(entry.done)
+	}
+	i.Wait()
+	i.RunExitHooks()
+	i.releaseValues()
+
+	return result
+}
+
+// Eval: Evaluate a Golsp program in the interpreter's top-level scope. Unlike
+// 'Run', definitions persist between calls and 'go' blocks are not waited for
+// `program`: the program to evaluate
+// this function returns the result of the last statement in the program
+func (i *Interpreter) Eval(program string) Object {
+	return evalModule(i.scope, program)
+}
+
+// EvalFile: Evaluate a program that was read from a file in the interpreter's
+// top-level scope, like 'Eval'. __dirname__ and __filename__ refer to the file
+// while it is evaluated, so that it requires modules relative to itself
+// `filename`: the name of the file
+// `program`: the program to evaluate
+// this function returns the result of the last statement in the program
+func (i *Interpreter) EvalFile(filename string, program string) Object {
+	dirname, name := i.scope.Identifiers[DIRNAME], i.scope.Identifiers[FILENAME]
+	defer func () {
+		i.scope.Identifiers[DIRNAME], i.scope.Identifiers[FILENAME] = dirname, name
+	}()
+	if absfilename, err := filepath.Abs(filename); err == nil { filename = absfilename }
+	i.scope.Identifiers[DIRNAME] = StringObject(filepath.Dir(filename))
+	i.scope.Identifiers[FILENAME] = StringObject(filename)
+
+	return evalModule(i.scope, program)
+}
+
+// evalModule: Evaluate the statements of a program directly in a module's scope.
+// Unlike a block (see 'Eval'), the scope is not isolated, so that definitions are
+// made in it and 'export' can tell it apart from the blocks in the module
+// `scope`: the module's scope
+// `program`: the program
+// this function returns the result of the last statement in the program
+func evalModule(scope Scope, program string) Object {
+	result := UndefinedObject()
+	for _, child := range MakeST(Tokenize(program)).Children {
+		if child.Spread {
+			spread := SpreadNode(scope, child)
+			if spread.Length > 0 { result = spread.Last.Object }
+		} else {
+			result = Eval(scope, child)
+		}
+	}
+
+	return result
+}
+
+// Reset: Discard every definition made by 'Eval', so that the interpreter's
+// top-level scope is empty again. Modules remain cached (see 'InvalidateModules')
+func (i *Interpreter) Reset() {
+	dirname, _ := os.Getwd()
+	i.scope = i.moduleScope(dirname, "-", []string{})
+	i.releaseValues()
+}
+
+// Value: Find the value that a native module keeps in the interpreter under a key,
+// i.e the files that it has opened, creating it the first time it is looked up.
+// Values are released (and closed, if they are io.Closers) when the program ends
+// and when the interpreter is reset, so module state is never shared between
+// interpreters or kept alive by a package. Keys should be of an unexported type,
+// like context keys
+// `key`: the key
+// `create`: the function that creates the value, or nil to only look it up
+// this function returns the value, or nil if there is none and `create` is nil
+func (i *Interpreter) Value(key interface{}, create func() interface{}) interface{} {
+	i.valuesMutex.Lock()
+	defer i.valuesMutex.Unlock()
+	if value, exists := i.values[key]; exists || create == nil { return value }
+
+	value := create()
+	i.values[key] = value
+	return value
+}
+
+// SetValue: Replace the value that is kept under a key (see 'Value'), or remove it
+// if `value` is nil. The value that is replaced is not closed
+func (i *Interpreter) SetValue(key interface{}, value interface{}) {
+	i.valuesMutex.Lock()
+	defer i.valuesMutex.Unlock()
+	if value == nil {
+		delete(i.values, key)
+		return
+	}
+	i.values[key] = value
+}
+
+// releaseValues: Remove every value kept by the interpreter, closing the ones that
+// are io.Closers
+func (i *Interpreter) releaseValues() {
+	i.valuesMutex.Lock()
+	values := i.values
+	i.values = make(map[interface{}]interface{})
+	i.valuesMutex.Unlock()
+
+	for _, value := range values {
+		if closer, ok := value.(io.Closer); ok { closer.Close() }
+	}
+}
+
+// Call: Call a function object with a set of arguments
+// `fn`: the function object
+// `args`: the arguments
+// this function returns the result of the function call
+func (i *Interpreter) Call(fn Object, args ...Object) Object {
+	if fn.Type == ObjectTypeFunction && fn.Function.BuiltinFunc != nil {
+		result, _ := bindBuiltins(fn.Function.BuiltinFunc(i.scope, args), i)
+		return result
+	}
+
+	return CallFunction(fn, ListFromSlice(args))
+}
+
+// Lookup: Look up an identifier in the interpreter's top-level scope
+// `identifier`: the identifier
+// this function returns the object that the identifier is bound to, or UNDEFINED
+func (i *Interpreter) Lookup(identifier string) Object {
+	return LookupIdentifier(i.scope, identifier)
+}
+
+// Wait: Wait for all of the interpreter's 'go' blocks and timers to complete
+func (i *Interpreter) Wait() {
+	i.waitGroup.Wait()
+}
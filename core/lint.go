@@ -0,0 +1,420 @@
+
+// Linter
+
+package golsp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// lint rules (see 'LintRules')
+const (
+	LINT_UNREACHABLE_PATTERN = "unreachable-pattern"
+	LINT_CONSTANT = "const-assign"
+	LINT_UNDEFINED = "undefined"
+	LINT_UNUSED = "unused"
+)
+
+// LintRules: the rules that 'Lint' checks, and what they report
+var LintRules = map[string]string{
+	LINT_UNREACHABLE_PATTERN: "function patterns that are never matched because an earlier pattern " +
+		"matches every argument that they match, i.e [factorial 0] after [factorial n]",
+	LINT_CONSTANT: "definitions of constants (including builtins), which have no effect",
+	LINT_UNDEFINED: "identifiers that are used before they are defined, or are never defined",
+	LINT_UNUSED: "definitions and imports that are never used",
+}
+
+// Diagnostic: a problem that 'Lint' found in a program, the line on which it
+// occurs (starting from 1) and the rule that found it
+type Diagnostic struct {
+	Line int
+	Rule string
+	Message string
+}
+
+func (diagnostic Diagnostic) String() string {
+	return fmt.Sprintf("line %d: %s (%s)", diagnostic.Line, diagnostic.Message, diagnostic.Rule)
+}
+
+// lintBinding: what the linter knows about an identifier in a scope
+type lintBinding struct {
+	line int
+	constant bool
+	builtin bool
+	// parameter is whether the identifier is bound by a function pattern (or by
+	// the interpreter, i.e '__args__'), so it is not reported when it is unused
+	parameter bool
+	exported bool
+	used bool
+	// patterns are the patterns of the function that the identifier is bound to,
+	// in the order that they were defined
+	patterns []lintPattern
+}
+
+type lintPattern struct {
+	nodes []STNode
+	line int
+}
+
+// lintScope: the identifiers that are bound in a block -- a module, a function
+// body, or a 'do' or 'go' block
+type lintScope struct {
+	parent *lintScope
+	bindings map[string]*lintBinding
+	// order is the order in which identifiers were first bound, so that unused
+	// identifiers are reported in order
+	order []string
+	// pending are the function bodies that are defined in the block. Bodies are
+	// checked after the rest of the block, since they are not evaluated until
+	// the function is called
+	pending []func()
+}
+
+func (scope *lintScope) lookup(identifier string) *lintBinding {
+	for s := scope; s != nil; s = s.parent {
+		if binding, exists := s.bindings[identifier]; exists { return binding }
+	}
+
+	return nil
+}
+
+func (scope *lintScope) child() *lintScope {
+	return &lintScope{parent: scope, bindings: make(map[string]*lintBinding)}
+}
+
+// linter: collects diagnostics while walking a syntax tree
+type linter struct {
+	diagnostics []Diagnostic
+}
+
+func (l *linter) report(line int, rule string, format string, args ...interface{}) {
+	l.diagnostics = append(l.diagnostics, Diagnostic{line, rule, fmt.Sprintf(format, args...)})
+}
+
+// block: check a series of statements that are evaluated in order in the same
+// scope, then the bodies of the functions that they define, then whether each
+// identifier that the block defines is used
+func (l *linter) block(scope *lintScope, statements []STNode) {
+	for _, statement := range statements { l.expression(scope, statement) }
+	for len(scope.pending) > 0 {
+		pending := scope.pending
+		scope.pending = nil
+		for _, fn := range pending { fn() }
+	}
+
+	// the last statement is the value of the block, so what it defines is used
+	if len(statements) > 0 {
+		if name, defines := definedName(statements[len(statements) - 1]); defines {
+			if binding, exists := scope.bindings[name]; exists { binding.used = true }
+		}
+	}
+
+	for _, name := range scope.order {
+		binding := scope.bindings[name]
+		if binding.used || binding.exported || binding.parameter || strings.HasPrefix(name, "_") { continue }
+		l.report(binding.line, LINT_UNUSED, "%s is defined but never used", name)
+	}
+}
+
+// definedName: find the identifier that a 'def' or 'const' statement defines
+// this function returns the identifier and whether the statement is a definition
+func definedName(statement STNode) (string, bool) {
+	if statement.Type != STNodeTypeExpression || len(statement.Children) < 3 { return "", false }
+	head := statement.Children[0]
+	if head.Head != "def" && head.Head != "const" { return "", false }
+
+	symbol := statement.Children[1]
+	if symbol.Type == STNodeTypeExpression && len(symbol.Children) > 0 { symbol = symbol.Children[0] }
+	if symbol.Type != STNodeTypeIdentifier { return "", false }
+
+	return symbol.Head, true
+}
+
+// expression: check an expression and the operands of its zip operators
+func (l *linter) expression(scope *lintScope, node STNode) {
+	switch node.Type {
+	case STNodeTypeIdentifier:
+		l.reference(scope, node)
+	case STNodeTypeList, STNodeTypeMap:
+		for _, child := range node.Children { l.expression(scope, child) }
+	case STNodeTypeExpression:
+		l.call(scope, node)
+	}
+
+	// the operands of dot operators are keys, not identifiers
+	for operand := &node; operand != nil; operand = operand.Dot {
+		if operand.Zip != nil { l.expression(scope, *operand.Zip) }
+	}
+}
+
+func (l *linter) reference(scope *lintScope, node STNode) {
+	if node.Head == UNDEFINED { return }
+	binding := scope.lookup(node.Head)
+	if binding == nil {
+		l.report(node.Line, LINT_UNDEFINED, "%s is not defined", node.Head)
+		return
+	}
+	binding.used = true
+}
+
+// call: check an expression, handling the builtins that bind identifiers or
+// evaluate their arguments in a scope of their own
+func (l *linter) call(scope *lintScope, node STNode) {
+	if len(node.Children) == 0 { return }
+	head, args := node.Children[0], node.Children[1:]
+
+	builtin := false
+	if head.Type == STNodeTypeIdentifier && !head.Spread && head.Zip == nil && head.Dot == nil {
+		binding := scope.lookup(head.Head)
+		builtin = binding != nil && binding.builtin
+	}
+
+	switch {
+	case builtin && (head.Head == "def" || head.Head == "const"):
+		// definitions without a value do nothing (see 'assign')
+		if len(args) >= 2 { l.definition(scope, args, head.Head == "const", false) }
+		return
+	case builtin && head.Head == "lambda" && len(args) >= 2 && args[0].Type == STNodeTypeExpression:
+		l.function(scope, args[0].Children, args[1])
+		return
+	case builtin && (head.Head == "do" || head.Head == "go" || head.Head == "detach"):
+		l.block(scope.child(), args)
+		return
+	case builtin && head.Head == "import" && len(args) >= 2:
+		l.expression(scope, args[0])
+		for _, arg := range args[1:] {
+			if arg.Type != STNodeTypeIdentifier { continue }
+			if arg.Zip != nil && arg.Zip.Type == STNodeTypeIdentifier {
+				l.bind(scope, *arg.Zip, true, false)
+			} else {
+				l.bind(scope, arg, true, false)
+			}
+		}
+		return
+	case builtin && head.Head == "export":
+		if len(args) > 2 && args[0].Type == STNodeTypeIdentifier &&
+			(args[0].Head == "def" || args[0].Head == "const") {
+			l.definition(scope, args[1:], args[0].Head == "const", true)
+			return
+		}
+		for _, arg := range args {
+			l.expression(scope, arg)
+			if binding := scope.lookup(arg.Head); arg.Type == STNodeTypeIdentifier && binding != nil {
+				binding.exported = true
+			}
+		}
+		return
+	}
+
+	for _, child := range node.Children { l.expression(scope, child) }
+}
+
+// definition: check a 'def' or 'const' statement
+// `args`: the symbol and the value of the definition
+// `constant`: whether the statement defines a constant
+// `exported`: whether the statement is exported with 'export'
+func (l *linter) definition(scope *lintScope, args []STNode, constant bool, exported bool) {
+	symbol, value := args[0], args[1]
+
+	switch symbol.Type {
+	case STNodeTypeIdentifier:
+		l.expression(scope, value)
+		if binding := l.bind(scope, symbol, constant, exported); binding != nil { binding.patterns = nil }
+
+	case STNodeTypeList, STNodeTypeMap:
+		l.expression(scope, value)
+		for _, node := range patternIdentifiers(symbol) { l.bind(scope, node, constant, exported) }
+
+	case STNodeTypeExpression:
+		if len(symbol.Children) == 0 || symbol.Children[0].Type != STNodeTypeIdentifier { return }
+		head, pattern := symbol.Children[0], symbol.Children[1:]
+		if binding := l.bind(scope, head, constant, exported); binding != nil {
+			l.pattern(binding, head.Head, pattern, head.Line)
+		}
+		l.function(scope, pattern, value)
+	}
+}
+
+// bind: record that an identifier is defined in a scope, reporting definitions of
+// constants (see 'isConstant')
+// this function returns the identifier's binding, or nil if it is a constant
+func (l *linter) bind(scope *lintScope, node STNode, constant bool, exported bool) *lintBinding {
+	for s := scope; s != nil; s = s.parent {
+		binding, exists := s.bindings[node.Head]
+		if !exists || !binding.constant { continue }
+		if binding.builtin {
+			l.report(node.Line, LINT_CONSTANT, "%s is a builtin, so this definition has no effect", node.Head)
+		} else {
+			l.report(node.Line, LINT_CONSTANT, "%s is a constant (defined on line %d), so this definition has no effect",
+				node.Head, binding.line)
+		}
+		return nil
+	}
+
+	binding, exists := scope.bindings[node.Head]
+	if !exists {
+		binding = &lintBinding{line: node.Line}
+		scope.bindings[node.Head] = binding
+		scope.order = append(scope.order, node.Head)
+	}
+	if constant { binding.constant = true }
+	if exported { binding.exported = true }
+
+	return binding
+}
+
+// pattern: record a pattern of a function, reporting it if an earlier pattern
+// makes it unreachable. Identical patterns replace each other (see 'assign')
+func (l *linter) pattern(binding *lintBinding, name string, pattern []STNode, line int) {
+	for i, earlier := range binding.patterns {
+		if comparePatterns(earlier.nodes, pattern) {
+			binding.patterns[i].line = line
+			return
+		}
+	}
+
+	for _, earlier := range binding.patterns {
+		if !subsumesPattern(earlier.nodes, pattern) { continue }
+		l.report(line, LINT_UNREACHABLE_PATTERN,
+			"pattern %s is unreachable, because %s (line %d) matches every argument that it matches",
+			patternString(name, pattern), patternString(name, earlier.nodes), earlier.line)
+		break
+	}
+
+	binding.patterns = append(binding.patterns, lintPattern{pattern, line})
+}
+
+// function: check a function, whose body is checked after the rest of the
+// block (see 'lintScope')
+// `pattern`: the function's pattern, whose identifiers are bound in its body
+// `body`: the function's body
+func (l *linter) function(scope *lintScope, pattern []STNode, body STNode) {
+	frame := scope.child()
+	for _, node := range pattern {
+		if node.Type == STNodeTypeExpression { l.expression(scope, node) }
+		for _, identifier := range patternIdentifiers(node) {
+			frame.bindings[identifier.Head] = &lintBinding{line: identifier.Line, parameter: true}
+		}
+	}
+
+	scope.pending = append(scope.pending, func () { l.block(frame, []STNode{body}) })
+}
+
+// patternIdentifiers: find the identifiers that a pattern binds (see 'bindArguments')
+func patternIdentifiers(node STNode) []STNode {
+	switch node.Type {
+	case STNodeTypeIdentifier:
+		return []STNode{node}
+
+	case STNodeTypeList:
+		identifiers := []STNode{}
+		for _, child := range node.Children {
+			identifiers = append(identifiers, patternIdentifiers(child)...)
+		}
+		return identifiers
+
+	case STNodeTypeMap:
+		identifiers := []STNode{}
+		for _, child := range node.Children {
+			if child.Type == STNodeTypeIdentifier { identifiers = append(identifiers, child) }
+			if child.Zip != nil { identifiers = append(identifiers, patternIdentifiers(*child.Zip)...) }
+		}
+		return identifiers
+	}
+
+	return nil
+}
+
+// subsumesPattern: check whether a function pattern matches every list of arguments
+// that another pattern of the same length matches, so that the other pattern is never
+// matched if it is defined later (see 'matchPatterns'). Patterns that spread are not
+// compared
+func subsumesPattern(pattern1 []STNode, pattern2 []STNode) bool {
+	if len(pattern1) != len(pattern2) { return false }
+	for i := range pattern1 {
+		if !subsumesNode(pattern1[i], pattern2[i]) { return false }
+	}
+
+	return true
+}
+
+func subsumesNode(node1 STNode, node2 STNode) bool {
+	if node1.Spread || node2.Spread { return false }
+
+	switch node1.Type {
+	case STNodeTypeIdentifier:
+		return true
+	case STNodeTypeStringLiteral, STNodeTypeNumberLiteral:
+		return node2.Type == node1.Type && node2.Head == node1.Head
+	case STNodeTypeList:
+		return node2.Type == STNodeTypeList && subsumesPattern(node1.Children, node2.Children)
+	}
+
+	return false
+}
+
+// patternString: print a function pattern, i.e `[factorial 0]`
+func patternString(name string, pattern []STNode) string {
+	nodes := []string{name}
+	for _, node := range pattern { nodes = append(nodes, nodeString(node)) }
+
+	return "[" + strings.Join(nodes, " ") + "]"
+}
+
+func nodeString(node STNode) string {
+	str := node.Head
+	if node.Type == STNodeTypeStringLiteral { str = strings.ReplaceAll(str, "\n", "\\n") }
+	if node.Type == STNodeTypeList || node.Type == STNodeTypeMap || node.Type == STNodeTypeExpression {
+		children := []string{}
+		for _, child := range node.Children { children = append(children, nodeString(child)) }
+		switch node.Type {
+		case STNodeTypeList: str = "{ " + strings.Join(children, " ") + " }"
+		case STNodeTypeMap: str = "( " + strings.Join(children, " ") + " )"
+		default: str = "[" + strings.Join(children, " ") + "]"
+		}
+		if len(children) == 0 { str = strings.ReplaceAll(str, "  ", "") }
+	}
+	if node.Spread { str += "..." }
+	if node.Dot != nil { str += "." + nodeString(*node.Dot) }
+	if node.Zip != nil { str += ": " + nodeString(*node.Zip) }
+
+	return str
+}
+
+// Lint: check a program for likely mistakes without running it -- function patterns
+// that can never be matched, definitions of constants, identifiers that are not
+// defined and definitions that are not used (see 'LintRules'). The interpreter's
+// builtins (including those added with 'Register') are assumed to be defined
+// `program`: the program to check
+// `disabled`: the rules that are not checked
+// this function returns the problems that were found, ordered by line, and an
+// optional error -- a SyntaxError if the program is invalid
+func (i *Interpreter) Lint(program string, disabled map[string]bool) ([]Diagnostic, error) {
+	tokens := Tokenize(program)
+	if err := CheckSyntax(tokens); err != nil { return nil, err }
+	root := MakeST(tokens)
+
+	builtins := &lintScope{bindings: make(map[string]*lintBinding)}
+	for identifier := range i.Builtins.Identifiers {
+		builtins.bindings[identifier] = &lintBinding{constant: true, builtin: true}
+	}
+	module := builtins.child()
+	for _, identifier := range []string{DIRNAME, FILENAME, ARGS} {
+		module.bindings[identifier] = &lintBinding{constant: true, builtin: true, parameter: true}
+	}
+
+	l := linter{}
+	l.block(module, root.Children)
+
+	diagnostics := []Diagnostic{}
+	for _, diagnostic := range l.diagnostics {
+		if !disabled[diagnostic.Rule] { diagnostics = append(diagnostics, diagnostic) }
+	}
+	sort.SliceStable(diagnostics, func (a int, b int) bool {
+		return diagnostics[a].Line < diagnostics[b].Line
+	})
+
+	return diagnostics, nil
+}
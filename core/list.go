@@ -3,8 +3,14 @@
 
 package golsp
 
+import "sync/atomic"
+
+// Item: a single item in a List. Items are shared between lists that are
+// sliced or joined from each other, so `next` is only ever set once and
+// is accessed atomically
+
 type Item struct {
-	next *Item
+	next atomic.Pointer[Item]
 	Object Object
 }
 
@@ -71,7 +77,7 @@ func (l *List) Next(item *Item, index int) *Item {
 	branch, exists := l.branches[index]
 	if exists { return branch }
 
-	return item.next
+	return item.next.Load()
 }
 
 func (l *List) ToSlice() []Object {
@@ -105,12 +111,9 @@ func (l *List) Append(obj Object) {
 		return
 	}
 
-	if l.Last.next == nil {
-		l.Last.next = &newitem
-		return
-	}
+	if l.Last.next.CompareAndSwap(nil, &newitem) { return }
 
-	if l.branches == nil { l.branches = make(map[int]*Item) }
+	l.branches = copyBranches(l.branches)
 	l.branches[l.Length - 1] = &newitem
 }
 
@@ -120,6 +123,7 @@ func (self *List) Join(other List) {
 	defer func() {
 		if (other.Length == 0) { return }
 
+		if len(other.branches) > 0 { self.branches = copyBranches(self.branches) }
 		for index, branch := range other.branches {
 			self.branches[index + self.Length] = branch
 		}
@@ -132,15 +136,23 @@ func (self *List) Join(other List) {
 		return
 	}
 
-	if self.Last.next == nil {
-		self.Last.next = other.First
-		return
-	}
+	if self.Last.next.CompareAndSwap(nil, other.First) { return }
 
-	if self.branches == nil { self.branches = make(map[int]*Item) }
+	self.branches = copyBranches(self.branches)
 	self.branches[self.Length - 1] = other.First
 }
 
+// copyBranches: Copy the branches of a list. Lists that are copied by value
+// share their branches, so branches are copied before they are modified
+// `branches`: the branches to copy
+// this function returns a copy of branches
+func copyBranches(branches map[int]*Item) map[int]*Item {
+	newbranches := make(map[int]*Item, len(branches) + 1)
+	for index, branch := range branches { newbranches[index] = branch }
+
+	return newbranches
+}
+
 func (l *List) Index(index int) Object {
 	if index < 0 { index += l.Length }
 
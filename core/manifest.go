@@ -0,0 +1,135 @@
+
+// Project manifests
+
+package golsp
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MANIFEST_FILE is the name of the file that declares a project's name, version
+// and dependencies
+const MANIFEST_FILE = "golsp.mod"
+
+// Manifest: A parsed manifest file, i.e
+//
+//	# comments begin with '#'
+//	module myproject
+//	version 1.0.0
+//	require greet ../greet
+//	require util archives/util-1.2.0.tar.gz 1.2.0
+//
+// Each dependency has a name, a source (a local directory or file, or a '.tar',
+// '.tar.gz', '.tgz' or '.zip' archive, relative to the manifest) and optionally the
+// version that the source's own manifest must declare
+type Manifest struct {
+	Path string
+	Name string
+	Version string
+	Dependencies []Dependency
+}
+
+type Dependency struct {
+	Name string
+	Source string
+	Version string
+}
+
+// ParseManifest: Parse the contents of a manifest file
+// `path`: the path of the manifest file, used to resolve sources and in errors
+// `data`: the contents of the file
+// this function returns the manifest and an optional error
+func ParseManifest(path string, data string) (Manifest, error) {
+	manifest := Manifest{Path: path}
+	for index, line := range strings.Split(data, "\n") {
+		if comment := strings.Index(line, "#"); comment >= 0 { line = line[:comment] }
+		fields := strings.Fields(line)
+		if len(fields) == 0 { continue }
+
+		fail := func (message string) (Manifest, error) {
+			return Manifest{}, fmt.Errorf("%s:%d: %s", path, index + 1, message)
+		}
+
+		switch fields[0] {
+		case "module":
+			if len(fields) != 2 { return fail("usage: module name") }
+			manifest.Name = fields[1]
+		case "version":
+			if len(fields) != 2 { return fail("usage: version version") }
+			manifest.Version = fields[1]
+		case "require":
+			if len(fields) < 3 || len(fields) > 4 {
+				return fail("usage: require name source [version]")
+			}
+			if !isBareName(fields[1]) || strings.ContainsAny(fields[1], `/\`) {
+				return fail("invalid dependency name " + fields[1])
+			}
+			if _, exists := manifest.Dependency(fields[1]); exists {
+				return fail("duplicate dependency " + fields[1])
+			}
+			dependency := Dependency{Name: fields[1], Source: fields[2]}
+			if len(fields) == 4 { dependency.Version = fields[3] }
+			manifest.Dependencies = append(manifest.Dependencies, dependency)
+		default:
+			return fail("unknown directive " + fields[0])
+		}
+	}
+
+	if manifest.Name == "" { return Manifest{}, fmt.Errorf("%s: missing module name", path) }
+
+	return manifest, nil
+}
+
+// ReadManifest: Read and parse a manifest file
+// `path`: the path of the manifest file
+// this function returns the manifest and an optional error
+func ReadManifest(path string) (Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil { return Manifest{}, err }
+
+	return ParseManifest(path, string(data))
+}
+
+// FindManifest: Find the manifest of the project that a directory belongs to,
+// by looking for a manifest file in the directory and each of its parents
+// `dirname`: the directory
+// this function returns the path of the manifest file, or "" if there is none
+func FindManifest(dirname string) string {
+	dir, err := filepath.Abs(dirname)
+	if err != nil { return "" }
+
+	for {
+		path := filepath.Join(dir, MANIFEST_FILE)
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() { return path }
+		if filepath.Dir(dir) == dir { return "" }
+		dir = filepath.Dir(dir)
+	}
+}
+
+// Dir: Find the directory of the project that a manifest belongs to
+func (manifest Manifest) Dir() string {
+	return filepath.Dir(manifest.Path)
+}
+
+// Dependency: Look up a dependency by name
+// `name`: the name of the dependency
+// this function returns the dependency and whether it is declared
+func (manifest Manifest) Dependency(name string) (Dependency, bool) {
+	for _, dependency := range manifest.Dependencies {
+		if dependency.Name == name { return dependency, true }
+	}
+
+	return Dependency{}, false
+}
+
+// IsArchive: Check whether a dependency's source is an archive
+func (dependency Dependency) IsArchive() bool {
+	for _, extension := range []string{".tar", ".tar.gz", ".tgz", ".zip"} {
+		if strings.HasSuffix(dependency.Source, extension) { return true }
+	}
+
+	return false
+}
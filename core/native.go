@@ -0,0 +1,134 @@
+
+// Native modules
+
+package golsp
+
+import (
+	"io/fs"
+	"plugin"
+	"sort"
+	"sync"
+)
+
+// NATIVE_PREFIX is the prefix of 'require' paths that name native modules,
+// i.e `[require "native:os"]`
+const NATIVE_PREFIX = "native:"
+
+// STDLIB_PREFIX is the prefix of 'require' paths that name standard library
+// modules, i.e `[require "stdlib/os.golsp"]`
+const STDLIB_PREFIX = "stdlib/"
+
+// stdlibFiles is the filesystem that contains the standard library's '.golsp'
+// files, usually embedded into the program (see 'RegisterStdlib')
+var stdlibFiles fs.FS
+
+// RegisterStdlib: Set the filesystem that interpreters load standard library
+// modules from by default. Importing the 'stdlib' package registers the embedded
+// standard library
+// `fsys`: the filesystem, which contains 'os.golsp', 'types.golsp' etc. at its root
+func RegisterStdlib(fsys fs.FS) {
+	nativeModulesMutex.Lock()
+	defer nativeModulesMutex.Unlock()
+	stdlibFiles = fsys
+}
+
+func registeredStdlib() fs.FS {
+	nativeModulesMutex.RLock()
+	defer nativeModulesMutex.RUnlock()
+
+	return stdlibFiles
+}
+
+// nativeModules is the registry of modules that are implemented in Go and
+// compiled into the program. Modules usually register themselves in an 'init'
+// function, so importing a module's package is enough to make it available
+var nativeModules = make(map[string]Object)
+var nativeModulesMutex sync.RWMutex
+
+// RegisterModule: Add a native module to the registry, replacing any module that
+// is already registered with the same name
+// `name`: the name of the module, i.e "os" for `[require "native:os"]`
+// `exports`: the object that the module exports
+func RegisterModule(name string, exports Object) {
+	nativeModulesMutex.Lock()
+	defer nativeModulesMutex.Unlock()
+	nativeModules[name] = exports
+}
+
+// NativeModule: Look up a native module in the registry
+// `name`: the name of the module
+// this function returns the object that the module exports and whether the module
+// is registered
+func NativeModule(name string) (Object, bool) {
+	nativeModulesMutex.RLock()
+	defer nativeModulesMutex.RUnlock()
+	exports, exists := nativeModules[name]
+
+	return exports, exists
+}
+
+// NativeModules: List the names of the registered native modules
+// this function returns the names, in sorted order
+func NativeModules() []string {
+	nativeModulesMutex.RLock()
+	defer nativeModulesMutex.RUnlock()
+
+	names := make([]string, 0, len(nativeModules))
+	for name := range nativeModules { names = append(names, name) }
+	sort.Strings(names)
+
+	return names
+}
+
+// loadPlugin: Load a native module from a Go plugin (i.e a '.so' file built with
+// `go build -buildmode=plugin`) that exports an 'Exports' Object. Plugins are an
+// optional way to extend Golsp without rebuilding it, and must be built with exactly
+// the same toolchain and dependencies as the program that loads them
+// `path`: the path of the plugin file
+// this function returns the object that the plugin exports, or an error object
+func loadPlugin(path string) Object {
+	plug, err := plugin.Open(path)
+	if err != nil { return ErrorObject(err.Error()) }
+	exportssym, err := plug.Lookup("Exports")
+	if err != nil { return ErrorObject(err.Error()) }
+	exports, ok := exportssym.(*Object)
+	if !ok { return ErrorObject("plugin 'Exports' is not an Object: " + path) }
+
+	return *exports
+}
+
+// bindBuiltins: Bind the builtin functions in an object that do not belong to an
+// interpreter (i.e those of native modules and plugins, which are shared by every
+// interpreter), so that 'CallFunction' calls them with the interpreter's scope
+// instead of the zero Scope. Maps are searched recursively, and copied if any of
+// their values change
+// `obj`: the object
+// `interp`: the interpreter
+// this function returns the bound object and whether it is different from `obj`
+func bindBuiltins(obj Object, interp *Interpreter) (Object, bool) {
+	if interp == nil { return obj, false }
+
+	switch obj.Type {
+	case ObjectTypeFunction:
+		if obj.Function.BuiltinFunc == nil || obj.Scope.interp != nil { return obj, false }
+		obj.Scope = Scope{interp: interp}
+		return obj, true
+
+	case ObjectTypeMap:
+		var bound map[string]Object
+		for key, value := range obj.Map {
+			value, changed := bindBuiltins(value, interp)
+			if !changed { continue }
+			if bound == nil {
+				bound = make(map[string]Object, len(obj.Map))
+				for k, v := range obj.Map { bound[k] = v }
+			}
+			bound[key] = value
+		}
+		if bound == nil { return obj, false }
+		obj.Map = bound
+		return obj, true
+	}
+
+	return obj, false
+}
@@ -50,16 +50,100 @@ var TokenDelimiterTypes = map[string]STNodeType{
 // `tokens`: list of tokens to parse
 func MakeST(tokens []string) STNode {
 	root := STNode{Type: STNodeTypeScope}
-	root.Children, _ = makeST(tokens[0], tokens[1:])
+	lines := tokenLines(tokens)
+	root.Children, _ = makeST(tokens[0], tokens[1:], lines[1:])
 	return root
 }
 
+// SyntaxError: a problem with a program's syntax that 'MakeST' would silently
+// ignore, and the line on which it occurs (starting from 1)
+type SyntaxError struct {
+	Line int
+	Message string
+}
+
+func (err SyntaxError) Error() string {
+	return fmt.Sprintf("line %d: %s", err.Line, err.Message)
+}
+
+// CheckSyntax: check that every bracket in a list of tokens is closed by the
+// matching bracket and that every string literal is terminated
+// `tokens`: list of tokens to check
+// this function returns the first problem as a SyntaxError, or nil
+func CheckSyntax(tokens []string) error {
+	type opener struct {
+		token string
+		line int
+	}
+	lines := tokenLines(tokens)
+	openers := []opener{}
+
+	for i, token := range tokens {
+		if strings.HasPrefix(token, "\"") && !terminated(token) {
+			return SyntaxError{lines[i], "unterminated string literal"}
+		}
+
+		closing, delimiter := TokenDelimiters[token]
+		if !delimiter || token == "" { continue }
+		if closing != "" {
+			openers = append(openers, opener{token, lines[i]})
+			continue
+		}
+
+		if len(openers) == 0 {
+			return SyntaxError{lines[i], fmt.Sprintf("unexpected '%s'", token)}
+		}
+		last := openers[len(openers) - 1]
+		if expected := TokenDelimiters[last.token]; token != expected {
+			return SyntaxError{lines[i], fmt.Sprintf("expected '%s' to close '%s' from line %d, found '%s'",
+				expected, last.token, last.line, token)}
+		}
+		openers = openers[:len(openers) - 1]
+	}
+
+	if len(openers) > 0 {
+		last := openers[len(openers) - 1]
+		return SyntaxError{last.line, fmt.Sprintf("'%s' is never closed", last.token)}
+	}
+
+	return nil
+}
+
+// terminated: check whether a string literal token ends with an unescaped '"'
+func terminated(token string) bool {
+	if len(token) < 2 || !strings.HasSuffix(token, "\"") { return false }
+
+	escapes := 0
+	for i := len(token) - 2; i > 0 && token[i] == '\\'; i-- { escapes++ }
+
+	return escapes % 2 == 0
+}
+
+// tokenLines: find the line on which each token in a list of tokens begins
+// `tokens`: list of tokens
+// this function returns a list of line numbers, one for each token
+func tokenLines(tokens []string) []int {
+	lines := make([]int, len(tokens))
+	line := 0
+	for i, token := range tokens {
+		lines[i] = line
+		if token == "\n" {
+			line++
+		} else if strings.HasPrefix(token, "\"") {
+			line += strings.Count(token, "\n")
+		}
+	}
+
+	return lines
+}
+
 // makeST: recursively construct a syntax tree from a list of tokens
 // `delim`: the leading delimeter of the current expression
 // `tokens`: remaining tokens to parse
+// `lines`: the line on which each of the remaining tokens begins
 // this function returns a list of nodes within the current expression
 // and a list of remaining unparsed tokens
-func makeST(delim string, tokens []string) ([]STNode, []string) {
+func makeST(delim string, tokens []string, lines []int) ([]STNode, []string) {
 	nodes := make([]STNode, 0, len(tokens))
 	zip := false
 	dot := false
@@ -79,6 +163,7 @@ func makeST(delim string, tokens []string) ([]STNode, []string) {
 				node := STNode{
 					Type: STNodeTypeExpression,
 					Children: make([]STNode, len(nodes[prevlength:])),
+					Line: nodes[prevlength].Line,
 				}
 				copy(node.Children, nodes[prevlength:])
 				nodes = nodes[:prevlength]
@@ -93,6 +178,7 @@ func makeST(delim string, tokens []string) ([]STNode, []string) {
 			Head: tokens[i],
 			Type: STNodeTypeIdentifier,
 			Children: make([]STNode, 0),
+			Line: lines[i],
 		}
 
 		// check if current token is a delimiter '[]' or '{}'
@@ -101,9 +187,10 @@ func makeST(delim string, tokens []string) ([]STNode, []string) {
 		if isDelimiter {
 			var newtokens []string
 			current.Type = delimtype
-			current.Children, newtokens = makeST(current.Head, tokens[i + 1:])
+			current.Children, newtokens = makeST(current.Head, tokens[i + 1:], lines[i + 1:])
 			i = -1
 			tokens = newtokens
+			lines = lines[len(lines) - len(tokens):]
 			nodes, prev, zip, dot = appendNode(nodes, current, prev, zip, dot)
 			continue
 		}
@@ -255,7 +342,9 @@ func matchOperator(runes []rune, index int) int {
 // `input`: the string to tokenize
 // this function returns a list of tokens
 func Tokenize(input string) []string {
-	input = strings.TrimSpace(input)
+	// leading whitespace is not trimmed, since leading newlines
+	// determine the line on which each token begins
+	input = strings.TrimRightFunc(input, unicode.IsSpace)
 	runes := []rune(input)
 	token := ""
 	tokens := []string{token, "\n"}
@@ -0,0 +1,167 @@
+
+// Module resolution
+
+package golsp
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MODULES_DIR is the name of project-local module directories, which are searched
+// for bare module names in the directory of the requiring file and each of its parents
+const MODULES_DIR = "golsp_modules"
+
+// INDEX_FILE is the module that is loaded when a directory is required
+const INDEX_FILE = "index.golsp"
+
+// Resolution: The result of resolving a module name -- the path of the module
+// ("" if it was not found), every path that was tried, in order, and why the
+// module could not be found if there is more to it than the paths that were tried
+// (i.e the project's manifest is invalid)
+type Resolution struct {
+	Name string
+	Path string
+	Tried []string
+	Err error
+}
+
+// isBareName: Check whether a module name is 'bare', i.e neither a relative
+// ("./a.golsp", "../a.golsp"), absolute, standard library or native module name
+func isBareName(name string) bool {
+	return !filepath.IsAbs(name) &&
+		!strings.HasPrefix(name, STDLIB_PREFIX) &&
+		!strings.HasPrefix(name, NATIVE_PREFIX) &&
+		name != "." && name != ".." &&
+		!strings.HasPrefix(name, "./") && !strings.HasPrefix(name, "../")
+}
+
+// isStdlibPath: Check whether a path refers to the (virtual) standard library
+// directory or a file in it (see 'readModule')
+func isStdlibPath(path string) bool {
+	return path == filepath.Clean(STDLIB_PREFIX) || strings.HasPrefix(path, STDLIB_PREFIX)
+}
+
+// searchDirs: List the directories that a module name is looked up in
+// `dirname`: the directory of the requiring file
+// `name`: the module name
+// this function returns the directories, in the order that they are searched
+func (i *Interpreter) searchDirs(dirname string, name string) []string {
+	if filepath.IsAbs(name) || strings.HasPrefix(name, STDLIB_PREFIX) { return []string{""} }
+
+	dirs := []string{dirname}
+	if !isBareName(name) { return dirs }
+
+	// bare names are looked up relative to the requiring file first, so that
+	// `[require "a.golsp"]` still refers to a sibling file
+	if absdirname, err := filepath.Abs(dirname); err == nil && !isStdlibPath(dirname) {
+		for dir := absdirname; ; dir = filepath.Dir(dir) {
+			dirs = append(dirs, filepath.Join(dir, MODULES_DIR))
+			if filepath.Dir(dir) == dir { break }
+		}
+	}
+
+	return append(dirs, i.SearchPath...)
+}
+
+// Resolve: Find the module that a name refers to. Relative names are resolved
+// relative to `dirname`, and absolute names and 'stdlib/' names are used as they
+// are. Bare names that refer to a dependency in the project's manifest are resolved
+// through it (see 'resolveDependency'). Other bare names are looked up relative to
+// `dirname`, then in a 'golsp_modules' directory in `dirname` or any of its parents,
+// and then in each directory of SearchPath (see 'tryModule')
+// `dirname`: the directory of the requiring file
+// `name`: the module name
+// this function returns the resolution
+func (i *Interpreter) Resolve(dirname string, name string) Resolution {
+	resolution := Resolution{Name: name}
+	if strings.HasPrefix(name, NATIVE_PREFIX) {
+		resolution.Tried = []string{name}
+		if _, exists := NativeModule(name[len(NATIVE_PREFIX):]); exists { resolution.Path = name }
+		return resolution
+	}
+
+	if isBareName(name) && !isStdlibPath(dirname) {
+		if manifestpath := FindManifest(dirname); manifestpath != "" {
+			manifest, err := ReadManifest(manifestpath)
+			if err != nil {
+				resolution.Err = err
+				return resolution
+			}
+			packagename := strings.SplitN(filepath.ToSlash(name), "/", 2)[0]
+			if dependency, declared := manifest.Dependency(packagename); declared {
+				i.resolveDependency(&resolution, manifest, dependency)
+				return resolution
+			}
+		}
+	}
+
+	for _, dir := range i.searchDirs(dirname, name) {
+		if i.tryModule(&resolution, filepath.Join(dir, name)) { break }
+	}
+
+	return resolution
+}
+
+// resolveDependency: Resolve a name that begins with the name of a dependency that
+// is declared in a project's manifest (see 'Manifest'). The name is looked up in the
+// project's 'golsp_modules' directory (see 'golsp mod vendor'), then next to the
+// project if it is vendored itself, and then in the dependency's source if it is a
+// local directory or file
+func (i *Interpreter) resolveDependency(resolution *Resolution, manifest Manifest, dependency Dependency) {
+	if i.tryModule(resolution, filepath.Join(manifest.Dir(), MODULES_DIR, resolution.Name)) { return }
+
+	// vendoring flattens dependencies, so the dependencies of a vendored module
+	// are its siblings
+	parent := filepath.Dir(manifest.Dir())
+	if filepath.Base(parent) == MODULES_DIR &&
+		i.tryModule(resolution, filepath.Join(parent, resolution.Name)) {
+		return
+	}
+	if dependency.IsArchive() {
+		// archives can only be required once they are extracted
+		resolution.Err = fmt.Errorf("%s is not vendored (run golsp mod vendor)", dependency.Name)
+		return
+	}
+
+	source := dependency.Source
+	if !filepath.IsAbs(source) { source = filepath.Join(manifest.Dir(), source) }
+	i.tryModule(resolution, filepath.Join(source, resolution.Name[len(dependency.Name):]))
+}
+
+// tryModule: Try the paths that a module might be at -- the path itself, the path
+// with a '.golsp' extension and the path as a directory that contains an index file
+// (see INDEX_FILE) -- and record them in a resolution
+// this function returns whether a module was found
+func (i *Interpreter) tryModule(resolution *Resolution, base string) bool {
+	for _, candidate := range []string{base, base + ".golsp", filepath.Join(base, INDEX_FILE)} {
+		resolution.Tried = append(resolution.Tried, candidate)
+		if i.isModuleFile(candidate) {
+			resolution.Path = candidate
+			return true
+		}
+	}
+
+	return false
+}
+
+// isModuleFile: Check whether a path refers to a regular file (see 'readModule')
+func (i *Interpreter) isModuleFile(path string) bool {
+	var info fs.FileInfo
+	var err error
+	switch {
+	case !strings.HasPrefix(path, STDLIB_PREFIX):
+		info, err = os.Stat(path)
+	case i.StdlibPath != "":
+		info, err = os.Stat(filepath.Join(i.StdlibPath, path))
+	case i.Stdlib != nil:
+		info, err = fs.Stat(i.Stdlib, strings.TrimPrefix(path, STDLIB_PREFIX))
+	default:
+		return false
+	}
+
+	return err == nil && info.Mode().IsRegular()
+}
@@ -0,0 +1,111 @@
+
+// Timers
+
+package golsp
+
+import (
+	"sync"
+	"time"
+)
+
+// timerArgs: Evaluate and check the arguments of 'after' and 'every',
+// i.e `[after 100 fn args...]`
+// `name`: the name of the builtin function
+// this function returns the delay, the (isolated) function, its arguments and an
+// optional error object
+func timerArgs(name string, scope Scope, args []Object) (time.Duration, Object, List, *Object) {
+	arguments := EvalArgs(scope, args)
+	if len(arguments) < 2 || arguments[1].Type != ObjectTypeFunction {
+		err := ErrorObject(name + " requires a delay and a function")
+		return 0, Object{}, List{}, &err
+	}
+
+	ms, err := ToNumber(arguments[0])
+	if err != nil || ms < 0 {
+		err := ErrorObject(name + " delay must be a non-negative number")
+		return 0, Object{}, List{}, &err
+	}
+
+	delay := time.Duration(ms * float64(time.Millisecond))
+	return delay, IsolateFunction(arguments[1]), ListFromSlice(arguments[2:]), nil
+}
+
+// BuiltinAfter: The builtin 'after' function. This function calls a function
+// with a set of arguments after a delay in milliseconds, without blocking,
+// i.e `[after 1000 printf "one second later\n"]`. A pending timer keeps the
+// program running
+// this function returns a future (see 'future') that resolves to the result of the
+// call. Cancelling the future stops the timer if it has not fired yet
+func BuiltinAfter(scope Scope, args []Object) Object {
+	delay, fn, fnargs, err := timerArgs("after", scope, args)
+	if err != nil { return *err }
+
+	fut := newFuture()
+	interp := scope.Interpreter()
+	interp.waitGroup.Add(1)
+	timer := time.AfterFunc(delay, func () {
+		defer interp.waitGroup.Done()
+		fut.resolve(Protect(func () Object { return CallFunction(fn, fnargs) }))
+	})
+	fut.onCancel = func () {
+		if timer.Stop() { interp.waitGroup.Done() }
+	}
+
+	return fut.object()
+}
+
+// BuiltinEvery: The builtin 'every' function. This function repeatedly calls a
+// function with a set of arguments, waiting for an interval in milliseconds before
+// each call, i.e `[every 1000 printf "tick\n"]`. A call never overlaps with the
+// previous one. The timer keeps the program running until it is cancelled
+// this function returns a future (see 'future') that never resolves -- cancelling
+// it stops the timer
+func BuiltinEvery(scope Scope, args []Object) Object {
+	interval, fn, fnargs, err := timerArgs("every", scope, args)
+	if err != nil { return *err }
+
+	fut := newFuture()
+	interp := scope.Interpreter()
+	// the mutex guards the timer, whether a call is in progress and whether the
+	// timer has been stopped. The program keeps running until the timer is stopped
+	// and any call that is in progress has finished
+	var mutex sync.Mutex
+	var timer *time.Timer
+	running, stopped := false, false
+	var tick func()
+	tick = func () {
+		mutex.Lock()
+		if stopped {
+			mutex.Unlock()
+			return
+		}
+		running = true
+		mutex.Unlock()
+
+		Protect(func () Object { return CallFunction(fn, fnargs) })
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		running = false
+		if stopped {
+			interp.waitGroup.Done()
+			return
+		}
+		timer = time.AfterFunc(interval, tick)
+	}
+
+	interp.waitGroup.Add(1)
+	mutex.Lock()
+	defer mutex.Unlock()
+	timer = time.AfterFunc(interval, tick)
+	fut.onCancel = func () {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if stopped { return }
+		stopped = true
+		timer.Stop()
+		if !running { interp.waitGroup.Done() }
+	}
+
+	return fut.object()
+}
@@ -0,0 +1,69 @@
+
+// Formatter
+
+package main
+
+import (
+	"fmt"
+	"os"
+	golsp "github.com/ajaymt/golsp/core"
+)
+
+func fmtCommand(args []string) int {
+	flags := newFlagSet("fmt")
+	write := flags.Bool("w", false, "write the formatted program to each file instead of printing it")
+	check := flags.Bool("check", false, "list the files that are not formatted instead of printing them, and fail if there are any")
+	flags.Parse(args)
+	if *write && *check {
+		fmt.Fprintf(os.Stderr, "golsp fmt: -w and -check cannot be used together\n")
+		return EXIT_USAGE
+	}
+
+	if flags.NArg() == 0 || (flags.NArg() == 1 && flags.Arg(0) == "-") {
+		if *write { return fail("cannot use -w with stdin") }
+		_, _, program, err := readProgram("-")
+		if err != nil { return fail("%v", err) }
+		formatted, err := golsp.FormatProgram(program)
+		if err != nil { return syntaxError("<stdin>", err) }
+		if *check {
+			if formatted == program { return EXIT_OK }
+			fmt.Println("<stdin>")
+			return EXIT_FAILURE
+		}
+		fmt.Print(formatted)
+		return EXIT_OK
+	}
+
+	filenames, err := findFiles(flags.Args(), ".golsp")
+	if err != nil { return fail("%v", err) }
+
+	status := EXIT_OK
+	for _, filename := range filenames {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			status = fail("%v", err)
+			continue
+		}
+		program := string(data)
+		formatted, err := golsp.FormatProgram(program)
+		if err != nil {
+			status = syntaxError(filename, err)
+			continue
+		}
+
+		switch {
+		case *check:
+			if formatted != program {
+				fmt.Println(filename)
+				status = EXIT_FAILURE
+			}
+		case *write:
+			if formatted == program { continue }
+			if err := os.WriteFile(filename, []byte(formatted), 0644); err != nil { status = fail("%v", err) }
+		default:
+			fmt.Print(formatted)
+		}
+	}
+
+	return status
+}
@@ -0,0 +1,78 @@
+
+// Linter
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	golsp "github.com/ajaymt/golsp/core"
+)
+
+// lintRules: list the lint rules and what they report, for 'golsp help lint'
+func lintRules() string {
+	names := make([]string, 0, len(golsp.LintRules))
+	for name := range golsp.LintRules { names = append(names, name) }
+	sort.Strings(names)
+
+	rules := "rules:\n"
+	for _, name := range names {
+		rules += fmt.Sprintf("  %-20s %s\n", name, golsp.LintRules[name])
+	}
+
+	return rules
+}
+
+func lintCommand(args []string) int {
+	flags := newFlagSet("lint")
+	disable := flags.String("disable", "", "comma-separated `rules` that are not checked")
+	usage := flags.Usage
+	flags.Usage = func () {
+		usage()
+		fmt.Fprintf(flags.Output(), "\n%s", lintRules())
+	}
+	flags.Parse(args)
+
+	disabled := map[string]bool{}
+	for _, rule := range strings.Split(*disable, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" { continue }
+		if _, exists := golsp.LintRules[rule]; !exists {
+			fmt.Fprintf(os.Stderr, "golsp lint: unknown rule %s\n\n%s", rule, lintRules())
+			return EXIT_USAGE
+		}
+		disabled[rule] = true
+	}
+
+	paths := flags.Args()
+	if len(paths) == 0 { paths = []string{"."} }
+	filenames := []string{"-"}
+	if len(paths) != 1 || paths[0] != "-" {
+		var err error
+		if filenames, err = findFiles(paths, ".golsp"); err != nil { return fail("%v", err) }
+	}
+
+	interp := golsp.NewInterpreter()
+	status := EXIT_OK
+	for _, filename := range filenames {
+		_, _, program, err := readProgram(filename)
+		if err != nil {
+			status = fail("%v", err)
+			continue
+		}
+		diagnostics, err := interp.Lint(program, disabled)
+		if err != nil {
+			status = syntaxError(filename, err)
+			continue
+		}
+
+		for _, diagnostic := range diagnostics {
+			fmt.Printf("%s:%d: %s (%s)\n", filename, diagnostic.Line, diagnostic.Message, diagnostic.Rule)
+			status = EXIT_FAILURE
+		}
+	}
+
+	return status
+}
@@ -0,0 +1,233 @@
+
+// Package manager
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	golsp "github.com/ajaymt/golsp/core"
+)
+
+// vendored: a dependency that has been copied into a project's golsp_modules
+// directory, and the absolute path of the source that it was copied from
+type vendored struct {
+	dependency golsp.Dependency
+	source string
+	version string
+}
+
+// modVendor: copy the dependencies declared in a project's manifest (and their
+// own dependencies) into the project's golsp_modules directory, replacing any copies
+// that are already there. Dependencies are always local directories, files or
+// archives, so vendoring never needs the network
+// `dirname`: a directory inside the project
+// `out`: where progress is reported
+// this function returns an optional error
+func modVendor(dirname string, out io.Writer) error {
+	manifestpath := golsp.FindManifest(dirname)
+	if manifestpath == "" {
+		return fmt.Errorf("no %s found in %s or any parent directory", golsp.MANIFEST_FILE, dirname)
+	}
+	manifest, err := golsp.ReadManifest(manifestpath)
+	if err != nil { return err }
+
+	modulesdir := filepath.Join(manifest.Dir(), golsp.MODULES_DIR)
+	if err := os.MkdirAll(modulesdir, 0755); err != nil { return err }
+
+	type pending struct {
+		dependency golsp.Dependency
+		dir string
+	}
+	queue := []pending{}
+	for _, dependency := range manifest.Dependencies {
+		queue = append(queue, pending{dependency, manifest.Dir()})
+	}
+
+	done := make(map[string]vendored)
+	order := []string{}
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+		dependency := next.dependency
+
+		source := dependency.Source
+		if !filepath.IsAbs(source) { source = filepath.Join(next.dir, source) }
+		if previous, exists := done[dependency.Name]; exists {
+			if previous.source != source {
+				return fmt.Errorf("conflicting sources for %s: %s and %s",
+					dependency.Name, previous.source, source)
+			}
+			continue
+		}
+
+		dest := filepath.Join(modulesdir, dependency.Name)
+		if err := os.RemoveAll(dest); err != nil { return err }
+		if dependency.IsArchive() {
+			err = extractArchive(source, dest)
+		} else {
+			err = copyModule(source, dest)
+		}
+		if err != nil { return fmt.Errorf("%s: %v", dependency.Name, err) }
+
+		// a vendored dependency's own dependencies are resolved relative to its
+		// original location, unless it came from an archive
+		sourcedir := source
+		if dependency.IsArchive() { sourcedir = dest }
+		version := ""
+		if depmanifest, err := golsp.ReadManifest(filepath.Join(dest, golsp.MANIFEST_FILE)); err == nil {
+			version = depmanifest.Version
+			for _, transitive := range depmanifest.Dependencies {
+				queue = append(queue, pending{transitive, sourcedir})
+			}
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("%s: %v", dependency.Name, err)
+		}
+		if dependency.Version != "" && dependency.Version != version {
+			return fmt.Errorf("%s: %s requires version %s, found %q",
+				dependency.Name, manifest.Name, dependency.Version, version)
+		}
+
+		done[dependency.Name] = vendored{dependency, source, version}
+		order = append(order, dependency.Name)
+		fmt.Fprintf(out, "vendored %s %s (%s)\n", dependency.Name, version, dependency.Source)
+	}
+
+	// modules.txt records what was vendored, for reviewing changes to golsp_modules
+	var record strings.Builder
+	record.WriteString("# name version source\n")
+	for _, name := range order {
+		fmt.Fprintf(&record, "%s %s %s\n", name, done[name].version, done[name].source)
+	}
+
+	return os.WriteFile(filepath.Join(modulesdir, "modules.txt"), []byte(record.String()), 0644)
+}
+
+// copyModule: copy a local dependency -- a single file becomes the index file of
+// the destination directory, and a directory is copied without its own golsp_modules
+func copyModule(source string, dest string) error {
+	info, err := os.Stat(source)
+	if err != nil { return err }
+	if !info.IsDir() { return copyFile(source, filepath.Join(dest, golsp.INDEX_FILE), info.Mode()) }
+
+	return filepath.WalkDir(source, func (path string, entry fs.DirEntry, err error) error {
+		if err != nil { return err }
+		if entry.IsDir() && entry.Name() == golsp.MODULES_DIR { return filepath.SkipDir }
+
+		relpath, _ := filepath.Rel(source, path)
+		target := filepath.Join(dest, relpath)
+		if entry.IsDir() { return os.MkdirAll(target, 0755) }
+		if !entry.Type().IsRegular() { return nil }
+
+		info, err := entry.Info()
+		if err != nil { return err }
+		return copyFile(path, target, info.Mode())
+	})
+}
+
+func copyFile(source string, dest string, mode fs.FileMode) error {
+	in, err := os.Open(source)
+	if err != nil { return err }
+	defer in.Close()
+
+	return writeFile(dest, in, mode)
+}
+
+func writeFile(dest string, in io.Reader, mode fs.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil { return err }
+	out, err := os.OpenFile(dest, os.O_WRONLY | os.O_CREATE | os.O_TRUNC, mode.Perm() | 0600)
+	if err != nil { return err }
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+// archiveFile: a regular file in an archive
+type archiveFile struct {
+	name string
+	mode fs.FileMode
+	open func() (io.ReadCloser, error)
+}
+
+// extractArchive: extract the regular files of a '.tar', '.tar.gz', '.tgz' or
+// '.zip' archive into a directory. If every file is inside the same top-level
+// directory (i.e 'greet-1.0.0/'), that directory is stripped
+func extractArchive(source string, dest string) error {
+	var files []archiveFile
+	if strings.HasSuffix(source, ".zip") {
+		reader, err := zip.OpenReader(source)
+		if err != nil { return err }
+		defer reader.Close()
+
+		for _, file := range reader.File {
+			if !file.Mode().IsRegular() { continue }
+			files = append(files, archiveFile{file.Name, file.Mode(), file.Open})
+		}
+	} else {
+		in, err := os.Open(source)
+		if err != nil { return err }
+		defer in.Close()
+
+		var stream io.Reader = in
+		if !strings.HasSuffix(source, ".tar") {
+			gz, err := gzip.NewReader(in)
+			if err != nil { return err }
+			stream = gz
+		}
+
+		reader := tar.NewReader(stream)
+		for {
+			header, err := reader.Next()
+			if err == io.EOF { break }
+			if err != nil { return err }
+			if header.Typeflag != tar.TypeReg { continue }
+
+			data, err := io.ReadAll(reader)
+			if err != nil { return err }
+			files = append(files, archiveFile{header.Name, fs.FileMode(header.Mode),
+				func () (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(data)), nil }})
+		}
+	}
+
+	prefix := commonDir(files)
+	for _, file := range files {
+		name := strings.TrimPrefix(filepath.ToSlash(file.name), prefix)
+		if !filepath.IsLocal(name) { return fmt.Errorf("invalid path in archive: %s", file.name) }
+
+		in, err := file.open()
+		if err != nil { return err }
+		err = writeFile(filepath.Join(dest, name), in, file.mode)
+		in.Close()
+		if err != nil { return err }
+	}
+
+	return nil
+}
+
+// commonDir: find the top-level directory that every file in an archive is
+// inside of, if there is one
+// this function returns the directory with a trailing slash, or ""
+func commonDir(files []archiveFile) string {
+	prefix := ""
+	for index, file := range files {
+		name := filepath.ToSlash(file.name)
+		slash := strings.Index(name, "/")
+		if slash < 0 { return "" }
+		if index == 0 { prefix = name[:slash + 1] }
+		if name[:slash + 1] != prefix { return "" }
+	}
+
+	return prefix
+}
@@ -0,0 +1,211 @@
+
+// REPL
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	golsp "github.com/ajaymt/golsp/core"
+)
+
+// HISTORY_FILE is the file in the user's home directory that REPL inputs are
+// appended to, so that they are kept between sessions
+const HISTORY_FILE = ".golsp_history"
+
+const replHelp = `Enter an expression to evaluate it. Input continues on the next line until
+every '[', '{' and '(' is closed. Definitions persist until the scope is reset.
+
+:load file    evaluate a file in the REPL's scope, requiring modules relative to it
+:ast expr     print the syntax tree of an expression
+:reset        discard every definition and forget cached modules
+:history      print previous inputs
+:help         print this message
+:quit         exit (or end the input, i.e ctrl-D)
+`
+
+// repl: The state of an interactive session -- the interpreter whose top-level
+// scope holds the session's definitions, and every input so far
+type repl struct {
+	interp *golsp.Interpreter
+	out io.Writer
+	history []string
+	historyfile string
+}
+
+// defaultHistoryFile: Find the history file in the user's home directory
+// this function returns the path of the file, or "" if there is no home directory
+func defaultHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil { return "" }
+
+	return filepath.Join(home, HISTORY_FILE)
+}
+
+// runREPL: Read, evaluate and print expressions until the input ends or ':quit'
+// is entered, then wait for 'go' blocks and run exit hooks like 'golsp.Run'
+// `interp`: the interpreter
+// `in`: where input is read from
+// `out`: where prompts and results are written
+// `historyfile`: the file that inputs are kept in between sessions, or "" for none
+func runREPL(interp *golsp.Interpreter, in io.Reader, out io.Writer, historyfile string) {
+	r := &repl{interp: interp, out: out, historyfile: historyfile}
+	if historyfile != "" {
+		if data, err := os.ReadFile(historyfile); err == nil {
+			r.history = parseHistory(string(data))
+		}
+	}
+
+	reader := bufio.NewReader(in)
+	input := ""
+	for {
+		if input == "" {
+			fmt.Fprint(out, "golsp> ")
+		} else {
+			fmt.Fprint(out, "...    ")
+		}
+
+		line, err := reader.ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Fprintln(out)
+			break
+		}
+
+		input += line
+		if incomplete(input) && err == nil { continue }
+		current := strings.TrimSpace(input)
+		input = ""
+		if current == "" { continue }
+
+		r.remember(current)
+		if strings.HasPrefix(current, ":") {
+			if !r.command(current) { break }
+			continue
+		}
+		r.eval(current, "")
+	}
+
+	interp.Wait()
+	interp.RunExitHooks()
+}
+
+// eval: Evaluate an input in the session's scope and print its result. Go panics
+// are printed as errors instead of ending the session
+// `input`: the input
+// `filename`: the file that the input was read from (see ':load'), or ""
+func (r *repl) eval(input string, filename string) {
+	result := golsp.Protect(func () golsp.Object {
+		if filename != "" { return r.interp.EvalFile(filename, input) }
+		return r.interp.Eval(input)
+	})
+	if result.Type == golsp.ObjectTypeLiteral && result.Value.Head == golsp.UNDEFINED { return }
+
+	fmt.Fprintln(r.out, golsp.Format(result))
+}
+
+// command: Run a REPL command, i.e ':load file'
+// `input`: the command and its argument
+// this function returns false if the session should end
+func (r *repl) command(input string) bool {
+	name, arg := input, ""
+	if space := strings.IndexAny(input, " \t\n"); space >= 0 {
+		name, arg = input[:space], strings.TrimSpace(input[space:])
+	}
+
+	switch name {
+	case ":quit", ":q":
+		return false
+	case ":help", ":h":
+		fmt.Fprint(r.out, replHelp)
+	case ":load", ":l":
+		if arg == "" {
+			fmt.Fprintln(r.out, "usage: :load file")
+			break
+		}
+		data, err := os.ReadFile(arg)
+		if err != nil {
+			fmt.Fprintln(r.out, err)
+			break
+		}
+		r.eval(string(data), arg)
+	case ":ast":
+		tree := golsp.MakeST(golsp.Tokenize(arg))
+		fmt.Fprintln(r.out, strings.TrimPrefix(PrintST(tree), "\n"))
+	case ":reset":
+		r.interp.Reset()
+		r.interp.InvalidateModules()
+	case ":history":
+		for index, entry := range r.history {
+			fmt.Fprintf(r.out, "%4d  %s\n", index + 1, strings.ReplaceAll(entry, "\n", "\n      "))
+		}
+	default:
+		fmt.Fprintf(r.out, "unknown command %s (see :help)\n", name)
+	}
+
+	return true
+}
+
+// remember: Add an input to the history, and append it to the history file.
+// Inputs that span several lines are stored with escaped newlines
+func (r *repl) remember(input string) {
+	r.history = append(r.history, input)
+	if r.historyfile == "" { return }
+
+	file, err := os.OpenFile(r.historyfile, os.O_WRONLY | os.O_CREATE | os.O_APPEND, 0600)
+	if err != nil { return }
+	defer file.Close()
+	escaped := strings.ReplaceAll(strings.ReplaceAll(input, `\`, `\\`), "\n", `\n`)
+	fmt.Fprintln(file, escaped)
+}
+
+// parseHistory: Parse the contents of a history file (see 'remember')
+// this function returns the inputs, oldest first
+func parseHistory(data string) []string {
+	history := []string{}
+	for _, line := range strings.Split(data, "\n") {
+		if line == "" { continue }
+
+		var entry strings.Builder
+		for i := 0; i < len(line); i++ {
+			if line[i] == '\\' && i + 1 < len(line) {
+				i++
+				if line[i] == 'n' {
+					entry.WriteByte('\n')
+					continue
+				}
+			}
+			entry.WriteByte(line[i])
+		}
+		history = append(history, entry.String())
+	}
+
+	return history
+}
+
+// incomplete: Check whether an input has unclosed brackets, ignoring brackets
+// inside of string literals and comments
+// this function returns true if the input continues on the next line
+func incomplete(input string) bool {
+	depth := 0
+	for i := 0; i < len(input); i++ {
+		switch input[i] {
+		case '[', '{', '(':
+			depth++
+		case ']', '}', ')':
+			depth--
+		case '#':
+			for i < len(input) && input[i] != '\n' { i++ }
+		case '"':
+			for i++; i < len(input) && input[i] != '"'; i++ {
+				if input[i] == '\\' { i++ }
+			}
+			if i >= len(input) { return true }
+		}
+	}
+
+	return depth > 0
+}
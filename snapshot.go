@@ -0,0 +1,297 @@
+
+// Snapshot tests
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GOLDEN_SUFFIX is the suffix of the file that stores the expected output of a
+// snapshot test, i.e 'hello.golden' for 'hello.golsp'
+const GOLDEN_SUFFIX = ".golden"
+
+// STDIN_SUFFIX is the suffix of the optional file that a snapshot test reads as
+// its standard input, i.e 'hello.stdin' for 'hello.golsp'
+const STDIN_SUFFIX = ".stdin"
+
+// ARGS_SUFFIX is the suffix of the optional file that lists the arguments that
+// golsp is run with instead of 'run hello.golsp', i.e 'hello.args' containing
+// 'lint hello.golsp'
+const ARGS_SUFFIX = ".args"
+
+// durationPattern matches the durations that 'golsp test' prints, i.e '(0.12s)',
+// which are replaced in snapshots because they change from run to run
+var durationPattern = regexp.MustCompile(`\(\d+\.\d+s\)`)
+
+// snapshot: the output of running a program -- what it wrote to stdout and
+// stderr, and its exit status
+type snapshot struct {
+	stdout string
+	stderr string
+	status int
+}
+
+// format: produce the contents of a golden file, i.e
+//
+//	-- stdout --
+//	hello
+//	-- stderr --
+//	-- status --
+//	0
+func (snap snapshot) format() string {
+	section := func (name string, contents string) string {
+		if contents != "" && !strings.HasSuffix(contents, "\n") { contents += "\n" }
+		return "-- " + name + " --\n" + contents
+	}
+
+	return section("stdout", snap.stdout) + section("stderr", snap.stderr) +
+		section("status", strconv.Itoa(snap.status))
+}
+
+// parseSnapshot: parse the contents of a golden file (see 'format')
+// this function returns the snapshot and an optional error
+func parseSnapshot(data string) (snapshot, error) {
+	sections := map[string]*strings.Builder{}
+	var current *strings.Builder
+	for _, line := range strings.SplitAfter(data, "\n") {
+		if name := strings.TrimSpace(line); strings.HasPrefix(name, "-- ") && strings.HasSuffix(name, " --") {
+			current = &strings.Builder{}
+			sections[strings.TrimSuffix(strings.TrimPrefix(name, "-- "), " --")] = current
+			continue
+		}
+		if current == nil && line != "" { return snapshot{}, errors.New("expected a '-- stdout --' section") }
+		if current != nil { current.WriteString(line) }
+	}
+
+	for _, name := range []string{"stdout", "stderr", "status"} {
+		if sections[name] == nil { return snapshot{}, fmt.Errorf("missing '-- %s --' section", name) }
+	}
+	status, err := strconv.Atoi(strings.TrimSpace(sections["status"].String()))
+	if err != nil { return snapshot{}, fmt.Errorf("invalid status: %v", err) }
+
+	return snapshot{sections["stdout"].String(), sections["stderr"].String(), status}, nil
+}
+
+// takeSnapshot: run a program with 'golsp run' (or with the arguments in its
+// .args file) in its own directory and record its output. The directory's absolute
+// path is replaced with '.' in the output and durations are replaced with '(N.NNs)',
+// so that snapshots do not depend on where the program is or how long it takes
+// `filename`: the program file
+// `timeout`: how long the program can run for before it is killed
+// this function returns the snapshot and an optional error
+func takeSnapshot(filename string, timeout time.Duration) (snapshot, error) {
+	executable, err := os.Executable()
+	if err != nil { return snapshot{}, err }
+	filename, _ = filepath.Abs(filename)
+	dirname := filepath.Dir(filename)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	args := []string{"run", filepath.Base(filename)}
+	argsname := strings.TrimSuffix(filename, ".golsp") + ARGS_SUFFIX
+	if data, err := os.ReadFile(argsname); err == nil {
+		args = strings.Fields(string(data))
+	}
+
+	cmd := exec.CommandContext(ctx, executable, args...)
+	cmd.Dir = dirname
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	stdinname := strings.TrimSuffix(filename, ".golsp") + STDIN_SUFFIX
+	if stdin, err := os.Open(stdinname); err == nil {
+		defer stdin.Close()
+		cmd.Stdin = stdin
+	}
+
+	err = cmd.Run()
+	if ctx.Err() != nil { return snapshot{}, fmt.Errorf("timed out after %v", timeout) }
+	status := 0
+	if exiterr, ok := err.(*exec.ExitError); ok {
+		status = exiterr.ExitCode()
+	} else if err != nil {
+		return snapshot{}, err
+	}
+
+	normalize := func (output string) string {
+		output = strings.ReplaceAll(output, dirname, ".")
+		return durationPattern.ReplaceAllLiteralString(output, "(N.NNs)")
+	}
+
+	return snapshot{normalize(stdout.String()), normalize(stderr.String()), status}, nil
+}
+
+// diffLines: compare two texts line by line, using the longest common subsequence
+// of their lines
+// this function returns the lines of both texts, each prefixed with ' ' if it is
+// in both, '-' if it is only in `expected` and '+' if it is only in `actual`
+func diffLines(expected string, actual string) []string {
+	a := strings.Split(strings.TrimSuffix(expected, "\n"), "\n")
+	b := strings.Split(strings.TrimSuffix(actual, "\n"), "\n")
+	if expected == "" { a = nil }
+	if actual == "" { b = nil }
+
+	// common[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	common := make([][]int, len(a) + 1)
+	for i := range common { common[i] = make([]int, len(b) + 1) }
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				common[i][j] = common[i + 1][j + 1] + 1
+			} else if common[i + 1][j] >= common[i][j + 1] {
+				common[i][j] = common[i + 1][j]
+			} else {
+				common[i][j] = common[i][j + 1]
+			}
+		}
+	}
+
+	lines := []string{}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, " " + a[i])
+			i, j = i + 1, j + 1
+		case j >= len(b) || (i < len(a) && common[i + 1][j] >= common[i][j + 1]):
+			lines = append(lines, "-" + a[i])
+			i++
+		default:
+			lines = append(lines, "+" + b[j])
+			j++
+		}
+	}
+
+	return lines
+}
+
+// printDiff: print the lines of a diff that changed, with up to `context`
+// unchanged lines around them
+func printDiff(name string, lines []string, context int) {
+	changed := make([]bool, len(lines))
+	for index, line := range lines {
+		if line[0] == ' ' { continue }
+		for k := index - context; k <= index + context; k++ {
+			if k >= 0 && k < len(lines) { changed[k] = true }
+		}
+	}
+
+	fmt.Printf("    --- expected %s\n    +++ actual %s\n", name, name)
+	skipped := false
+	for index, line := range lines {
+		if !changed[index] {
+			skipped = true
+			continue
+		}
+		if skipped { fmt.Printf("    ...\n") }
+		skipped = false
+		fmt.Printf("    %s\n", line)
+	}
+}
+
+// findSnapshots: find the snapshot tests in a list of files and directories.
+// Files are always included, and directories are searched recursively for programs
+// that have a golden file
+// this function returns the programs and an optional error
+func findSnapshots(paths []string) ([]string, error) {
+	files := []string{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil { return nil, err }
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		err = filepath.WalkDir(path, func (path string, entry fs.DirEntry, err error) error {
+			if err != nil { return err }
+			if entry.IsDir() || !strings.HasSuffix(path, ".golsp") { return nil }
+			if _, err := os.Stat(strings.TrimSuffix(path, ".golsp") + GOLDEN_SUFFIX); err == nil {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil { return nil, err }
+	}
+
+	return files, nil
+}
+
+func snapshotCommand(args []string) int {
+	flags := newFlagSet("snapshot")
+	update := flags.Bool("update", false, "write the output of each program to its golden file instead of comparing them")
+	timeout := flags.Duration("timeout", 10 * time.Second, "kill each program after this long")
+	flags.Parse(args)
+
+	paths := flags.Args()
+	if len(paths) == 0 { paths = []string{"."} }
+	filenames, err := findSnapshots(paths)
+	if err != nil { return fail("%v", err) }
+	if len(filenames) == 0 {
+		fmt.Println("no snapshot tests")
+		return EXIT_OK
+	}
+
+	failed := 0
+	for _, filename := range filenames {
+		goldenname := strings.TrimSuffix(filename, ".golsp") + GOLDEN_SUFFIX
+		actual, err := takeSnapshot(filename, *timeout)
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL  %s: %v\n", filename, err)
+			continue
+		}
+
+		if *update {
+			if err := os.WriteFile(goldenname, []byte(actual.format()), 0644); err != nil {
+				return fail("%v", err)
+			}
+			fmt.Printf("wrote %s\n", goldenname)
+			continue
+		}
+
+		data, err := os.ReadFile(goldenname)
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL  %s: %v (run with -update to create it)\n", filename, err)
+			continue
+		}
+		expected, err := parseSnapshot(string(data))
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL  %s: %s: %v\n", filename, goldenname, err)
+			continue
+		}
+		if expected.format() == actual.format() {
+			fmt.Printf("ok    %s\n", filename)
+			continue
+		}
+
+		failed++
+		fmt.Printf("FAIL  %s\n", filename)
+		if expected.stdout != actual.stdout { printDiff("stdout", diffLines(expected.stdout, actual.stdout), 3) }
+		if expected.stderr != actual.stderr { printDiff("stderr", diffLines(expected.stderr, actual.stderr), 3) }
+		if expected.status != actual.status {
+			fmt.Printf("    expected exit status %d, got %d\n", expected.status, actual.status)
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("FAIL: %d of %d snapshots failed\n", failed, len(filenames))
+		return EXIT_FAILURE
+	}
+	if !*update { fmt.Printf("PASS: %d snapshots\n", len(filenames)) }
+
+	return EXIT_OK
+}
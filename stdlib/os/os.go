@@ -1,226 +1,232 @@
 
-package main
+package os
 
 import (
 	"os"
 	"io"
 	"io/ioutil"
 	"bufio"
+	"errors"
+	"strings"
+	"sync"
 	g "github.com/ajaymt/golsp/core"
 )
 
+// a file's mutex must be held while its reader, writer or offset are used,
+// since files can be shared between 'go' blocks. The standard streams of an
+// interpreter are not necessarily *os.Files, so their 'file' is nil
 type file struct {
+	mutex sync.Mutex
 	file *os.File
 	reader *bufio.Reader
 	writer *bufio.Writer
 }
 
-var openFiles = []file{
-	file{file: os.Stdin, reader: bufio.NewReader(os.Stdin), writer: nil},
-	file{file: os.Stdout, reader: nil, writer: bufio.NewWriter(os.Stdout)},
-	file{file: os.Stderr, reader: nil, writer: bufio.NewWriter(os.Stderr)},
+// fileTable: the files that an interpreter has opened, indexed by the numbers
+// that 'open' and 'create' return. The first three are the interpreter's
+// Stdin, Stdout and Stderr
+type fileTable struct {
+	mutex sync.Mutex
+	files []*file
 }
 
-func cropen(scope g.Scope, args []g.Object, create bool) g.Object {
-	arguments := g.EvalArgs(scope, args)
-	filename, _ := g.ToString(arguments[0])
+// fileTableKey is the key that an interpreter keeps its file table under
+// (see 'g.Interpreter.Value')
+type fileTableKey struct{}
+
+// filesOf: Find the file table of the interpreter that a scope belongs to,
+// creating it the first time the interpreter uses a file
+func filesOf(scope g.Scope) *fileTable {
+	interp := scope.Interpreter()
+	return interp.Value(fileTableKey{}, func () interface{} {
+		return &fileTable{files: []*file{
+			&file{reader: bufio.NewReader(interp.Stdin)},
+			&file{writer: bufio.NewWriter(interp.Stdout)},
+			&file{writer: bufio.NewWriter(interp.Stderr)},
+		}}
+	}).(*fileTable)
+}
+
+// Close: Close the files that the interpreter opened, when the interpreter releases
+// its file table. The standard streams belong to the interpreter and stay open
+func (table *fileTable) Close() error {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	var err error
+	for _, f := range table.files {
+		if f.file == nil { continue }
+		f.mutex.Lock()
+		if f.writer != nil { f.writer.Flush() }
+		if closeerr := f.file.Close(); closeerr != nil && err == nil { err = closeerr }
+		f.mutex.Unlock()
+	}
+	table.files = nil
 
+	return err
+}
+
+// lookupFile: find an open file by its index in an interpreter's file table
+// this function returns the file, or an error if the index is out of range
+func lookupFile(scope g.Scope, index int) (*file, error) {
+	table := filesOf(scope)
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	if index < 0 || index >= len(table.files) { return nil, errors.New("bad file index") }
+	return table.files[index], nil
+}
+
+func cropen(scope g.Scope, filename string, create bool) (int, error) {
 	mode := os.O_RDWR
 	if create { mode = os.O_RDWR | os.O_CREATE }
 	f, err := os.OpenFile(filename, mode, 0644)
-	if err != nil { return g.UndefinedObject() }
+	if err != nil { return -1, err }
 
 	reader := bufio.NewReader(f)
 	writer := bufio.NewWriter(f)
-	openFiles = append(openFiles, file{file: f, reader: reader, writer: writer})
-
-	return g.NumberObject(float64(len(openFiles) - 1))
-}
 
-func open(s g.Scope, a []g.Object) g.Object { return cropen(s, a, false) }
-func create(s g.Scope, a []g.Object) g.Object { return cropen(s, a, true) }
+	table := filesOf(scope)
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	table.files = append(table.files, &file{file: f, reader: reader, writer: writer})
 
-func rm(scope g.Scope, args []g.Object, all bool) g.Object {
-	arguments := g.EvalArgs(scope, args)
-	path, _ := g.ToString(arguments[0])
-
-	rmf := os.Remove
-	if all { rmf = os.RemoveAll }
-	err := rmf(path)
-	if err != nil { return g.NumberObject(0.0) }
-
-	return g.NumberObject(1.0)
+	return len(table.files) - 1, nil
 }
 
-func remove(s g.Scope, a []g.Object) g.Object { return rm(s, a, false) }
-func removeAll(s g.Scope, a []g.Object) g.Object { return rm(s, a, true) }
-
-func mkdir(scope g.Scope, args []g.Object) g.Object {
-	arguments := g.EvalArgs(scope, args)
-	path, _ := g.ToString(arguments[0])
+func open(scope g.Scope, filename string) (int, error) { return cropen(scope, filename, false) }
+func create(scope g.Scope, filename string) (int, error) { return cropen(scope, filename, true) }
 
-	err := os.MkdirAll(path, 0755)
-	if err != nil { return g.NumberObject(0.0) }
+func remove(path string) (bool, error) { return true, os.Remove(path) }
+func removeAll(path string) (bool, error) { return true, os.RemoveAll(path) }
 
-	return g.NumberObject(1.0)
-}
+func mkdir(path string) (bool, error) { return true, os.MkdirAll(path, 0755) }
 
-func read(scope g.Scope, args []g.Object) g.Object {
-	arguments := g.EvalArgs(scope, args)
-	indexf, _ := g.ToNumber(arguments[0])
-	nf, _ := g.ToNumber(arguments[1])
-	index, n := int(indexf), int(nf)
-	if index < 0 || index >= len(openFiles) { return g.UndefinedObject() }
-	if n < 0 { return g.UndefinedObject() }
+func read(scope g.Scope, index int, n int) (string, error) {
+	readwriter, err := lookupFile(scope, index)
+	if err != nil { return "", err }
+	if n < 0 { return "", errors.New("negative read length") }
 
-	readwriter := openFiles[index]
-	if readwriter.reader == nil { return g.UndefinedObject() }
+	readwriter.mutex.Lock()
+	defer readwriter.mutex.Unlock()
+	if readwriter.reader == nil { return "", errors.New("file is not readable") }
 
 	bytes := make([]byte, n)
-	_, err := readwriter.reader.Read(bytes)
-	if err != nil && err != io.EOF { return g.UndefinedObject() }
+	_, err = readwriter.reader.Read(bytes)
+	if err != nil && err != io.EOF { return "", err }
 
-	return g.StringObject(string(bytes))
+	return string(bytes), nil
 }
 
-func readAll(scope g.Scope, args []g.Object) g.Object {
-	arguments := g.EvalArgs(scope, args)
-	indexf, _ := g.ToNumber(arguments[0])
-	index := int(indexf)
-	if index < 0 || index >= len(openFiles) { return g.UndefinedObject() }
-
-	readwriter := openFiles[index]
-	if readwriter.reader == nil { return g.UndefinedObject() }
+func readAll(scope g.Scope, index int) (string, error) {
+	readwriter, err := lookupFile(scope, index)
+	if err != nil { return "", err }
 
-	bytes := make([]byte, 0)
-	b, err := readwriter.reader.ReadByte()
-	for ; err == nil; b, err = readwriter.reader.ReadByte() {
-		bytes = append(bytes, b)
-	}
+	readwriter.mutex.Lock()
+	defer readwriter.mutex.Unlock()
+	if readwriter.reader == nil { return "", errors.New("file is not readable") }
 
-	if err != io.EOF { return g.UndefinedObject() }
+	bytes, err := ioutil.ReadAll(readwriter.reader)
+	if err != nil { return "", err }
 
-	return g.StringObject(string(bytes))
+	return string(bytes), nil
 }
 
-func readUntil(scope g.Scope, args []g.Object) g.Object {
-	arguments := g.EvalArgs(scope, args)
-	indexf, _ := g.ToNumber(arguments[0])
-	index := int(indexf)
-	delim, _ := g.ToString(arguments[1])
-	if index < 0 || index >= len(openFiles) || len(delim) == 0 {
-		return g.UndefinedObject()
-	}
+func readUntil(scope g.Scope, index int, delim string) (string, error) {
+	readwriter, err := lookupFile(scope, index)
+	if err != nil { return "", err }
+	if len(delim) == 0 { return "", errors.New("empty delimiter") }
 
-	readwriter := openFiles[index]
-	if readwriter.reader == nil { return g.UndefinedObject() }
+	readwriter.mutex.Lock()
+	defer readwriter.mutex.Unlock()
+	if readwriter.reader == nil { return "", errors.New("file is not readable") }
 
 	bytes, err := readwriter.reader.ReadBytes(delim[0])
-	if err != nil && err != io.EOF { return g.UndefinedObject() }
+	if err != nil && err != io.EOF { return "", err }
 
-	return g.StringObject(string(bytes[:len(bytes) - 1]))
+	return strings.TrimSuffix(string(bytes), delim[:1]), nil
 }
 
-func write(scope g.Scope, args []g.Object) g.Object {
-	arguments := g.EvalArgs(scope, args)
-	str, _ := g.ToString(arguments[1])
-	indexf, _ := g.ToNumber(arguments[0])
-	index := int(indexf)
-	if index < 0 || index >= len(openFiles) { return g.UndefinedObject() }
+func write(scope g.Scope, index int, str string) (int, error) {
+	readwriter, err := lookupFile(scope, index)
+	if err != nil { return 0, err }
 
-	readwriter := openFiles[index]
-	if readwriter.writer == nil { return g.UndefinedObject() }
+	readwriter.mutex.Lock()
+	defer readwriter.mutex.Unlock()
+	if readwriter.writer == nil { return 0, errors.New("file is not writable") }
 
 	nwritten, err := readwriter.writer.WriteString(str)
-	if err != nil { return g.UndefinedObject() }
+	if err != nil { return 0, err }
 
-	err = readwriter.writer.Flush()
-	if err != nil { return g.UndefinedObject() }
-
-	return g.NumberObject(float64(nwritten))
+	return nwritten, readwriter.writer.Flush()
 }
 
-func seek(scope g.Scope, args []g.Object) g.Object {
-	arguments := g.EvalArgs(scope, args)
-	indexf, _ := g.ToNumber(arguments[0])
-	index := int(indexf)
-	if index < 0 || index >= len(openFiles) { return g.UndefinedObject() }
-	posf, _ := g.ToNumber(arguments[1])
-	pos := int64(posf)
-	if pos < 0 { return g.UndefinedObject() }
-	whencef, _ := g.ToNumber(arguments[2])
-	whence := int(whencef)
-	if whence < 0 || whence > 2 { return g.UndefinedObject() }
+// seek's 'whence' is optional and defaults to 0 (relative to the start of the file)
+func seek(scope g.Scope, index int, pos int64, whence ...int) (int64, error) {
+	file, err := lookupFile(scope, index)
+	if err != nil { return 0, err }
+	if pos < 0 { return 0, errors.New("negative seek position") }
+	if len(whence) == 0 { whence = []int{io.SeekStart} }
+	if len(whence) > 1 || whence[0] < 0 || whence[0] > 2 {
+		return 0, errors.New("seek whence must be 0, 1 or 2")
+	}
 
-	file := openFiles[index]
-	newpos, err := file.file.Seek(pos, whence)
-	if err != nil { return g.UndefinedObject() }
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+	if file.file == nil { return 0, errors.New("file is not seekable") }
 
-	return g.NumberObject(float64(newpos))
+	return file.file.Seek(pos, whence[0])
 }
 
-func fileInfoToObject(fi os.FileInfo) g.Object {
-	isDir := 0.0
-	if fi.IsDir() { isDir = 1.0 }
-
-	return g.MapObject(map[string]g.Object{
-		"name": g.StringObject(fi.Name()),
-		"size": g.NumberObject(float64(fi.Size())),
-		"isDir": g.NumberObject(isDir),
-	})
+type fileInfo struct {
+	Name string `golsp:"name"`
+	Size int64 `golsp:"size"`
+	IsDir bool `golsp:"isDir"`
 }
 
-func stat(scope g.Scope, args []g.Object) g.Object {
-	arguments := g.EvalArgs(scope, args)
-	filename, _ := g.ToString(arguments[0])
+func makeFileInfo(fi os.FileInfo) fileInfo {
+	return fileInfo{Name: fi.Name(), Size: fi.Size(), IsDir: fi.IsDir()}
+}
 
-	fileinfo, err := os.Stat(filename)
-	if err != nil { return g.UndefinedObject() }
+func stat(filename string) (fileInfo, error) {
+	fi, err := os.Stat(filename)
+	if err != nil { return fileInfo{}, err }
 
-	return fileInfoToObject(fileinfo)
+	return makeFileInfo(fi), nil
 }
 
-func readDir(scope g.Scope, args []g.Object) g.Object {
-	arguments := g.EvalArgs(scope, args)
-	dirname, _ := g.ToString(arguments[0])
-
-	dirinfo, err := ioutil.ReadDir(dirname);
-	if err != nil { return g.UndefinedObject() }
+func readDir(dirname string) ([]fileInfo, error) {
+	dirinfo, err := ioutil.ReadDir(dirname)
+	if err != nil { return nil, err }
 
-	contents := g.List{}
-	for _, file := range dirinfo {
-		contents.Append(fileInfoToObject(file))
-	}
+	contents := make([]fileInfo, len(dirinfo))
+	for i, fi := range dirinfo { contents[i] = makeFileInfo(fi) }
 
-	// TODO write a list-object contructor?
-	return g.Object{
-		Type: g.ObjectTypeList,
-		Elements: contents,
-	}
+	return contents, nil
 }
 
-func exit(scope g.Scope, args []g.Object) g.Object {
-	arguments := g.EvalArgs(scope, args)
-	n, _ := g.ToNumber(arguments[0])
-	os.Exit(int(n))
-	return g.UndefinedObject()
+func exit(scope g.Scope, code int) {
+	scope.Interpreter().Exit(code)
 }
 
 var Exports = g.MapObject(map[string]g.Object{
 	"stdin": g.NumberObject(0.0),
 	"stdout": g.NumberObject(1.0),
 	"stderr": g.NumberObject(2.0),
-	"open": g.BuiltinFunctionObject("open", open),
-	"create": g.BuiltinFunctionObject("create", create),
-	"remove": g.BuiltinFunctionObject("remove", remove),
-	"removeAll": g.BuiltinFunctionObject("removeAll", removeAll),
-	"mkdir": g.BuiltinFunctionObject("mkdir", mkdir),
-	"read": g.BuiltinFunctionObject("read", read),
-	"readAll": g.BuiltinFunctionObject("readAll", readAll),
-	"readUntil": g.BuiltinFunctionObject("readUntil", readUntil),
-	"write": g.BuiltinFunctionObject("write", write),
-	"seek": g.BuiltinFunctionObject("seek", seek),
-	"stat": g.BuiltinFunctionObject("stat", stat),
-	"readDir": g.BuiltinFunctionObject("readDir", readDir),
-	"exit": g.BuiltinFunctionObject("exit", exit),
+	"open": g.GoFunctionObject("open", open),
+	"create": g.GoFunctionObject("create", create),
+	"remove": g.GoFunctionObject("remove", remove),
+	"removeAll": g.GoFunctionObject("removeAll", removeAll),
+	"mkdir": g.GoFunctionObject("mkdir", mkdir),
+	"read": g.GoFunctionObject("read", read),
+	"readAll": g.GoFunctionObject("readAll", readAll),
+	"readUntil": g.GoFunctionObject("readUntil", readUntil),
+	"write": g.GoFunctionObject("write", write),
+	"seek": g.GoFunctionObject("seek", seek),
+	"stat": g.GoFunctionObject("stat", stat),
+	"readDir": g.GoFunctionObject("readDir", readDir),
+	"exit": g.GoFunctionObject("exit", exit),
 })
+
+func init() {
+	g.RegisterModule("os", Exports)
+}
@@ -0,0 +1,527 @@
+
+package property
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	g "github.com/ajaymt/golsp/core"
+)
+
+// a generator is a function that is called with a 'random' function and a size,
+// i.e `[lambda [random size] [* 2 [random [+ size 1]]]]`. `[random n]` returns a number
+// from 0 to n - 1, and the size grows from 0 as a property is checked. Generators
+// are shrunk through the numbers that they draw (see 'shrink'), so a generator that
+// only uses 'random' for randomness never needs its own shrinking function
+
+// MAX_CHOICES is the number of random numbers that generating a single value can
+// draw, which keeps runaway generators from running forever
+const MAX_CHOICES = 100000
+
+// MAX_SHRINKS is the number of times that a property is checked while a failing
+// case is shrunk
+const MAX_SHRINKS = 2000
+
+// DEFAULT_ALPHABET is the characters that strings are generated from by default,
+// simplest (i.e the characters that strings shrink towards) first
+const DEFAULT_ALPHABET = "abcdefghijklmnopqrstuvwxyz0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ _-.,:;!?'()[]{}/\\\n\t"
+
+// source: the random numbers that a value is generated from. Every number is
+// recorded so that the value can be generated again from a modified list of
+// numbers (see 'shrink'). Numbers that are not in the prefix are drawn from the
+// random number generator, or are 0 if there is none
+type source struct {
+	rand *rand.Rand
+	prefix []int
+	choices []int
+}
+
+func (src *source) draw(n int) (int, error) {
+	if n < 1 { return 0, fmt.Errorf("random expects a positive number, got %d", n) }
+	if len(src.choices) >= MAX_CHOICES {
+		return 0, fmt.Errorf("a generator drew more than %d random numbers", MAX_CHOICES)
+	}
+
+	choice := 0
+	if index := len(src.choices); index < len(src.prefix) {
+		choice = src.prefix[index] % n
+	} else if src.rand != nil {
+		choice = src.rand.Intn(n)
+	}
+	src.choices = append(src.choices, choice)
+
+	return choice, nil
+}
+
+// draw: Call the 'random' function that is passed to a generator
+func draw(random g.Object, n int) (int, error) {
+	result := g.CallFunction(random, g.ListFromSlice([]g.Object{g.NumberObject(float64(n))}))
+	var choice int
+	if err := g.ToGo(result, &choice); err != nil {
+		if result.Type == g.ObjectTypeError { return 0, errors.New(g.Format(result)) }
+		return 0, err
+	}
+
+	return choice, nil
+}
+
+// generate: Call a generator
+// this function returns the generated value, which is an error object if the
+// generator failed
+func generate(gen g.Object, random g.Object, size int) g.Object {
+	if gen.Type != g.ObjectTypeFunction {
+		return g.ErrorObject(fmt.Sprintf("%v is not a generator", g.Format(gen)))
+	}
+
+	return g.Protect(func () g.Object {
+		return g.CallFunction(gen, g.ListFromSlice([]g.Object{random, g.NumberObject(float64(size))}))
+	})
+}
+
+// generator: Produce a generator from a Go function
+func generator(name string, fn func(random g.Object, size int) (g.Object, error)) g.Object {
+	return g.GoFunctionObject(name, fn)
+}
+
+// drawInt: Draw an integer from a range, such that 0 is drawn as the integer
+// that is closest to 0, then the integers that are next closest and so on
+func drawInt(random g.Object, min int, max int) (int, error) {
+	if min > max { return 0, fmt.Errorf("invalid range %d to %d", min, max) }
+	target := 0
+	if target < min { target = min }
+	if target > max { target = max }
+
+	choice, err := draw(random, max - min + 1)
+	if err != nil { return 0, err }
+
+	above, below := max - target, target - min
+	paired := above
+	if below < paired { paired = below }
+	if choice <= 2 * paired {
+		if choice % 2 == 1 { return target + (choice + 1) / 2, nil }
+		return target - choice / 2, nil
+	}
+	if above > below { return target + choice - paired, nil }
+
+	return target - (choice - paired), nil
+}
+
+// bounds: Check the optional bounds of a number generator
+// this function returns the bounds, or -size and size
+func bounds(name string, size int, limits []int) (int, int, error) {
+	switch len(limits) {
+	case 0: return -size, size, nil
+	case 2: return limits[0], limits[1], nil
+	}
+
+	return 0, 0, fmt.Errorf("%s expects no bounds, or a minimum and a maximum", name)
+}
+
+// drawLength: Draw the length of a list, which is at most the size. Each element
+// is preceded by a number that is 0 if the list ends, so shrinking that number
+// shortens the list
+func drawLength(random g.Object, size int, element func() error) error {
+	for length := 0; length < size; length++ {
+		more, err := draw(random, size + 1)
+		if err != nil { return err }
+		if more == 0 { return nil }
+		if err := element(); err != nil { return err }
+	}
+
+	return nil
+}
+
+func integer(limits ...int) g.Object {
+	return generator("integer", func (random g.Object, size int) (g.Object, error) {
+		min, max, err := bounds("integer", size, limits)
+		if err != nil { return g.UndefinedObject(), err }
+		n, err := drawInt(random, min, max)
+		return g.NumberObject(float64(n)), err
+	})
+}
+
+func number(limits ...int) g.Object {
+	return generator("number", func (random g.Object, size int) (g.Object, error) {
+		min, max, err := bounds("number", size, limits)
+		if err != nil { return g.UndefinedObject(), err }
+		whole, err := drawInt(random, min, max)
+		if err != nil { return g.UndefinedObject(), err }
+		fraction, err := draw(random, 1000)
+		// fractions are away from zero, so they would leave the range at its
+		// upper bound, or at its lower bound if it is negative
+		if err != nil || whole == max || (whole == min && min < 0) {
+			return g.NumberObject(float64(whole)), err
+		}
+		if whole < 0 { fraction = -fraction }
+
+		return g.NumberObject(float64(whole) + float64(fraction) / 1000), nil
+	})
+}
+
+func boolean() g.Object {
+	return generator("boolean", func (random g.Object, size int) (g.Object, error) {
+		choice, err := draw(random, 2)
+		return g.NumberObject(float64(choice)), err
+	})
+}
+
+func str(alphabet ...string) g.Object {
+	chars := []rune(DEFAULT_ALPHABET)
+	if len(alphabet) > 0 { chars = []rune(strings.Join(alphabet, "")) }
+
+	return generator("string", func (random g.Object, size int) (g.Object, error) {
+		if len(chars) == 0 { return g.UndefinedObject(), errors.New("string alphabet is empty") }
+		var builder strings.Builder
+		err := drawLength(random, size, func () error {
+			choice, err := draw(random, len(chars))
+			builder.WriteRune(chars[choice])
+			return err
+		})
+		return g.StringObject(builder.String()), err
+	})
+}
+
+func list(gen g.Object) g.Object {
+	return generator("list", func (random g.Object, size int) (g.Object, error) {
+		elements := []g.Object{}
+		err := drawLength(random, size, func () error {
+			element := generate(gen, random, size)
+			if element.Type == g.ObjectTypeError { return errors.New(g.Format(element)) }
+			elements = append(elements, element)
+			return nil
+		})
+		return g.Object{Type: g.ObjectTypeList, Elements: g.ListFromSlice(elements)}, err
+	})
+}
+
+func dict(keygen g.Object, valuegen g.Object) g.Object {
+	return generator("map", func (random g.Object, size int) (g.Object, error) {
+		object := g.MapObject(map[string]g.Object{})
+		err := drawLength(random, size, func () error {
+			key := generate(keygen, random, size)
+			value := generate(valuegen, random, size)
+			for _, obj := range []g.Object{key, value} {
+				if obj.Type == g.ObjectTypeError { return errors.New(g.Format(obj)) }
+			}
+			if key.Type != g.ObjectTypeLiteral || key.Value.Head == g.UNDEFINED {
+				return fmt.Errorf("map keys must be strings or numbers, got %v", g.Format(key))
+			}
+
+			if _, exists := object.Map[key.Value.Head]; !exists {
+				object.MapKeys = append(object.MapKeys, key)
+			}
+			object.Map[key.Value.Head] = value
+			return nil
+		})
+		return object, err
+	})
+}
+
+func constant(value g.Object) g.Object {
+	return generator("constant", func (random g.Object, size int) (g.Object, error) {
+		return value, nil
+	})
+}
+
+func element(values []g.Object) g.Object {
+	return generator("element", func (random g.Object, size int) (g.Object, error) {
+		if len(values) == 0 { return g.UndefinedObject(), errors.New("element expects a non-empty list") }
+		choice, err := draw(random, len(values))
+		return values[choice], err
+	})
+}
+
+func oneOf(gens []g.Object) g.Object {
+	return generator("oneOf", func (random g.Object, size int) (g.Object, error) {
+		if len(gens) == 0 { return g.UndefinedObject(), errors.New("oneOf expects a non-empty list") }
+		choice, err := draw(random, len(gens))
+		if err != nil { return g.UndefinedObject(), err }
+		return generate(gens[choice], random, size), nil
+	})
+}
+
+func tuple(gens []g.Object) g.Object {
+	return generator("tuple", func (random g.Object, size int) (g.Object, error) {
+		values := make([]g.Object, len(gens))
+		for i, gen := range gens {
+			values[i] = generate(gen, random, size)
+			if values[i].Type == g.ObjectTypeError { return values[i], nil }
+		}
+		return g.Object{Type: g.ObjectTypeList, Elements: g.ListFromSlice(values)}, nil
+	})
+}
+
+func record(gens g.Object) (g.Object, error) {
+	if gens.Type != g.ObjectTypeMap { return g.UndefinedObject(), errors.New("record expects a map of generators") }
+
+	return generator("record", func (random g.Object, size int) (g.Object, error) {
+		object := g.MapObject(map[string]g.Object{})
+		for _, key := range gens.MapKeys {
+			value := generate(gens.Map[key.Value.Head], random, size)
+			if value.Type == g.ObjectTypeError { return value, nil }
+			object.Map[key.Value.Head] = value
+			object.MapKeys = append(object.MapKeys, key)
+		}
+		return object, nil
+	}), nil
+}
+
+func transform(fn g.Object, gen g.Object) g.Object {
+	return generator("transform", func (random g.Object, size int) (g.Object, error) {
+		value := generate(gen, random, size)
+		if value.Type == g.ObjectTypeError { return value, nil }
+		return g.CallFunction(fn, g.ListFromSlice([]g.Object{value})), nil
+	})
+}
+
+func filter(predicate g.Object, gen g.Object) g.Object {
+	return generator("filter", func (random g.Object, size int) (g.Object, error) {
+		for attempt := 0; attempt < 100; attempt++ {
+			value := generate(gen, random, size)
+			if value.Type == g.ObjectTypeError { return value, nil }
+			if g.ToBoolean(g.CallFunction(predicate, g.ListFromSlice([]g.Object{value}))) {
+				return value, nil
+			}
+		}
+		return g.UndefinedObject(), errors.New("filter: no value satisfied the predicate in 100 attempts")
+	})
+}
+
+func bind(gen g.Object, fn g.Object) g.Object {
+	return generator("bind", func (random g.Object, size int) (g.Object, error) {
+		value := generate(gen, random, size)
+		if value.Type == g.ObjectTypeError { return value, nil }
+		return generate(g.CallFunction(fn, g.ListFromSlice([]g.Object{value})), random, size), nil
+	})
+}
+
+func sample(gen g.Object, count ...int) g.Object {
+	n := 10
+	if len(count) > 0 { n = count[0] }
+	src := &source{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	values := make([]g.Object, n)
+	for i := range values {
+		values[i] = generate(gen, g.GoFunctionObject("random", src.draw), i * 3)
+		src.choices = nil
+	}
+
+	return g.Object{Type: g.ObjectTypeList, Elements: g.ListFromSlice(values)}
+}
+
+// options: the options of 'check' and 'forAll', i.e `( "runs": 1000 "seed": 42 )`
+type options struct {
+	Runs int `golsp:"runs"`
+	Seed int64 `golsp:"seed"`
+	Size int `golsp:"size"`
+}
+
+// failure: a property that failed -- the case that it failed for, and the case
+// that it was shrunk to
+type failure struct {
+	seed int64
+	runs int
+	original g.Object
+	counterexample g.Object
+	result g.Object
+	shrinks int
+}
+
+// fails: Check a property for a value
+// this function returns whether the property failed (it produced a falsy value
+// or an error) and the value that it produced
+func fails(property g.Object, value g.Object) (bool, g.Object) {
+	result := g.Protect(func () g.Object {
+		return g.CallFunction(property, g.ListFromSlice([]g.Object{value}))
+	})
+
+	return result.Type == g.ObjectTypeError || !g.ToBoolean(result), result
+}
+
+// simpler: Compare two lists of random numbers, shorter lists first and then in
+// lexicographic order
+func simpler(a []int, b []int) bool {
+	if len(a) != len(b) { return len(a) < len(b) }
+	for i := range a {
+		if a[i] != b[i] { return a[i] < b[i] }
+	}
+
+	return false
+}
+
+// shrink: Find a simpler case for which a property fails, by generating values from
+// simpler lists of random numbers -- removing numbers, replacing them with 0 and
+// making them smaller -- until none of them is simpler and fails
+// `fail`: the failure, whose counterexample and result are replaced
+// `choices`: the random numbers that the failing value was generated from
+func shrink(gen g.Object, property g.Object, size int, fail *failure, choices []int) {
+	best := choices
+	attempts := 0
+	attempt := func (candidate []int) bool {
+		if attempts >= MAX_SHRINKS { return false }
+		attempts++
+
+		src := &source{prefix: candidate}
+		value := generate(gen, g.GoFunctionObject("random", src.draw), size)
+		if value.Type == g.ObjectTypeError || !simpler(src.choices, best) { return false }
+		failed, result := fails(property, value)
+		if !failed { return false }
+
+		best = src.choices
+		fail.counterexample, fail.result = value, result
+		fail.shrinks++
+		return true
+	}
+
+	for improved := true; improved && attempts < MAX_SHRINKS; {
+		improved = false
+		for _, block := range []int{8, 4, 2, 1} {
+			for i := 0; i + block <= len(best); {
+				candidate := append(append([]int{}, best[:i]...), best[i + block:]...)
+				if attempt(candidate) {
+					improved = true
+				} else {
+					i++
+				}
+			}
+		}
+
+		// binary search for the smallest number that still fails, in case the
+		// property fails for every number above some threshold
+		for i := 0; i < len(best); i++ {
+			for low, high := 0, best[i]; low < high; {
+				middle := (low + high) / 2
+				candidate := append([]int{}, best...)
+				candidate[i] = middle
+				if attempt(candidate) {
+					improved = true
+					if i >= len(best) { break }
+					high = best[i]
+				} else {
+					low = middle + 1
+				}
+			}
+		}
+	}
+}
+
+// defaultSeed: Find the seed that properties are checked with when none is given
+// -- $GOLSPSEED, so that a failure can be reproduced, or the current time
+func defaultSeed() (int64, error) {
+	if env := os.Getenv("GOLSPSEED"); env != "" {
+		seed, err := strconv.ParseInt(env, 10, 64)
+		if err != nil { return 0, fmt.Errorf("invalid GOLSPSEED %q", env) }
+		return seed, nil
+	}
+
+	return time.Now().UnixNano(), nil
+}
+
+// checkProperty: Check a property for many generated values
+// this function returns the failure, if the property failed, and an optional error
+// if the options are invalid or a generator failed
+func checkProperty(gen g.Object, property g.Object, opts []g.Object) (*failure, error) {
+	config := options{Runs: 100, Size: 30}
+	seeded := false
+	if len(opts) > 0 {
+		if opts[0].Type != g.ObjectTypeMap { return nil, errors.New("options must be a map") }
+		if err := g.ToGo(opts[0], &config); err != nil { return nil, err }
+		if _, exists := opts[0].Map[g.StringObject("runs").Value.Head]; !exists { config.Runs = 100 }
+		if _, exists := opts[0].Map[g.StringObject("size").Value.Head]; !exists { config.Size = 30 }
+		// 0 is a seed like any other
+		_, seeded = opts[0].Map[g.StringObject("seed").Value.Head]
+	}
+	if !seeded {
+		seed, err := defaultSeed()
+		if err != nil { return nil, err }
+		config.Seed = seed
+	}
+
+	rng := rand.New(rand.NewSource(config.Seed))
+	for run := 0; run < config.Runs; run++ {
+		size := run * config.Size / config.Runs
+		src := &source{rand: rng}
+		value := generate(gen, g.GoFunctionObject("random", src.draw), size)
+		if value.Type == g.ObjectTypeError { return nil, errors.New(g.Format(value)) }
+
+		if failed, result := fails(property, value); failed {
+			fail := &failure{config.Seed, run + 1, value, value, result, 0}
+			shrink(gen, property, size, fail, src.choices)
+			return fail, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// report: Describe a failure
+func (fail *failure) report() string {
+	lines := []string{
+		fmt.Sprintf("property failed after %d runs (seed %d)", fail.runs, fail.seed),
+		fmt.Sprintf("  counterexample: %v", g.Format(fail.counterexample)),
+		fmt.Sprintf("  original: %v (shrunk %d times)", g.Format(fail.original), fail.shrinks),
+		fmt.Sprintf("  result: %v", g.Format(fail.result)),
+		fmt.Sprintf("  reproduce with GOLSPSEED=%d", fail.seed),
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func check(gen g.Object, property g.Object, opts ...g.Object) (g.Object, error) {
+	fail, err := checkProperty(gen, property, opts)
+	if err != nil { return g.UndefinedObject(), err }
+	if fail == nil { return g.NumberObject(1.0), nil }
+
+	result := g.ErrorObject(fail.report())
+	details := map[string]g.Object{
+		"seed": g.NumberObject(float64(fail.seed)),
+		"counterexample": fail.counterexample,
+		"original": fail.original,
+	}
+	for _, key := range []string{"seed", "counterexample", "original"} {
+		keyobj := g.StringObject(key)
+		result.Map[keyobj.Value.Head] = details[key]
+		result.MapKeys = append(result.MapKeys, keyobj)
+	}
+
+	return result, nil
+}
+
+func forAll(scope g.Scope, gen g.Object, property g.Object, opts ...g.Object) (bool, error) {
+	fail, err := checkProperty(gen, property, opts)
+	if err != nil { return false, err }
+	if fail == nil { return true, nil }
+
+	interp := scope.Interpreter()
+	fmt.Fprintln(interp.Stderr, fail.report())
+	interp.Exit(1)
+
+	return false, nil
+}
+
+var Exports = g.MapObject(map[string]g.Object{
+	"integer": g.GoFunctionObject("integer", integer),
+	"number": g.GoFunctionObject("number", number),
+	"boolean": g.GoFunctionObject("boolean", boolean),
+	"string": g.GoFunctionObject("string", str),
+	"list": g.GoFunctionObject("list", list),
+	"map": g.GoFunctionObject("map", dict),
+	"constant": g.GoFunctionObject("constant", constant),
+	"element": g.GoFunctionObject("element", element),
+	"oneOf": g.GoFunctionObject("oneOf", oneOf),
+	"tuple": g.GoFunctionObject("tuple", tuple),
+	"record": g.GoFunctionObject("record", record),
+	"transform": g.GoFunctionObject("transform", transform),
+	"filter": g.GoFunctionObject("filter", filter),
+	"bind": g.GoFunctionObject("bind", bind),
+	"sample": g.GoFunctionObject("sample", sample),
+	"check": g.GoFunctionObject("check", check),
+	"forAll": g.GoFunctionObject("forAll", forAll),
+})
+
+func init() {
+	g.RegisterModule("property", Exports)
+}
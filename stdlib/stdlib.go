@@ -0,0 +1,26 @@
+
+// Standard library
+
+// importing this package registers every native standard library module
+// (see 'RegisterModule' in core/native.go) and the standard library's '.golsp'
+// files, which are embedded into the program (see 'RegisterStdlib'), i.e
+// `import _ "github.com/ajaymt/golsp/stdlib"`
+package stdlib
+
+import (
+	"embed"
+	g "github.com/ajaymt/golsp/core"
+	_ "github.com/ajaymt/golsp/stdlib/os"
+	_ "github.com/ajaymt/golsp/stdlib/property"
+	_ "github.com/ajaymt/golsp/stdlib/sync"
+	_ "github.com/ajaymt/golsp/stdlib/testing"
+	_ "github.com/ajaymt/golsp/stdlib/tools"
+	_ "github.com/ajaymt/golsp/stdlib/types"
+)
+
+//go:embed *.golsp
+var Files embed.FS
+
+func init() {
+	g.RegisterStdlib(Files)
+}
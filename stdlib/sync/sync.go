@@ -0,0 +1,160 @@
+
+package sync
+
+import (
+	"sync"
+	g "github.com/ajaymt/golsp/core"
+)
+
+// every primitive keeps track of its own state instead of relying on the
+// 'sync' package, so that misusing it produces an error instead of crashing
+
+func mutex(scope g.Scope, args []g.Object) g.Object {
+	locked := make(chan struct{}, 1)
+
+	lock := func (_ g.Scope, _ []g.Object) g.Object {
+		locked <- struct{}{}
+		return g.NumberObject(1.0)
+	}
+	unlock := func (_ g.Scope, _ []g.Object) g.Object {
+		select {
+		case <-locked:
+			return g.NumberObject(1.0)
+		default:
+			return g.ErrorObject("unlock of unlocked mutex")
+		}
+	}
+	tryLock := func (_ g.Scope, _ []g.Object) g.Object {
+		select {
+		case locked <- struct{}{}:
+			return g.NumberObject(1.0)
+		default:
+			return g.NumberObject(0.0)
+		}
+	}
+	withLock := func (scope g.Scope, args []g.Object) g.Object {
+		locked <- struct{}{}
+		defer func () { <-locked }()
+		return g.BuiltinDo(scope, args)
+	}
+
+	return g.MapObject(map[string]g.Object{
+		"lock": g.BuiltinFunctionObject("lock", lock),
+		"unlock": g.BuiltinFunctionObject("unlock", unlock),
+		"tryLock": g.BuiltinFunctionObject("tryLock", tryLock),
+		"withLock": g.BuiltinFunctionObject("withLock", withLock),
+	})
+}
+
+func semaphore(scope g.Scope, args []g.Object) g.Object {
+	arguments := g.EvalArgs(scope, args)
+	if len(arguments) < 1 {
+		return g.ErrorObject("semaphore requires a number of permits")
+	}
+	nf, err := g.ToNumber(arguments[0])
+	n := int(nf)
+	if err != nil || n < 1 {
+		return g.ErrorObject("semaphore permits must be a positive number")
+	}
+
+	permits := make(chan struct{}, n)
+
+	acquire := func (_ g.Scope, _ []g.Object) g.Object {
+		permits <- struct{}{}
+		return g.NumberObject(1.0)
+	}
+	release := func (_ g.Scope, _ []g.Object) g.Object {
+		select {
+		case <-permits:
+			return g.NumberObject(1.0)
+		default:
+			return g.ErrorObject("release of semaphore without acquire")
+		}
+	}
+	tryAcquire := func (_ g.Scope, _ []g.Object) g.Object {
+		select {
+		case permits <- struct{}{}:
+			return g.NumberObject(1.0)
+		default:
+			return g.NumberObject(0.0)
+		}
+	}
+	withPermit := func (scope g.Scope, args []g.Object) g.Object {
+		permits <- struct{}{}
+		defer func () { <-permits }()
+		return g.BuiltinDo(scope, args)
+	}
+
+	return g.MapObject(map[string]g.Object{
+		"acquire": g.BuiltinFunctionObject("acquire", acquire),
+		"release": g.BuiltinFunctionObject("release", release),
+		"tryAcquire": g.BuiltinFunctionObject("tryAcquire", tryAcquire),
+		"withPermit": g.BuiltinFunctionObject("withPermit", withPermit),
+	})
+}
+
+func waitGroup(scope g.Scope, args []g.Object) g.Object {
+	var mutex sync.Mutex
+	cond := sync.NewCond(&mutex)
+	count := 0
+
+	addn := func (n int) g.Object {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if count + n < 0 { return g.ErrorObject("negative wait group counter") }
+		count += n
+		if count == 0 { cond.Broadcast() }
+
+		return g.NumberObject(float64(count))
+	}
+
+	add := func (scope g.Scope, args []g.Object) g.Object {
+		arguments := g.EvalArgs(scope, args)
+		if len(arguments) < 1 { return addn(1) }
+
+		n, err := g.ToNumber(arguments[0])
+		if err != nil { return g.ErrorObject("wait group delta must be a number") }
+
+		return addn(int(n))
+	}
+	done := func (_ g.Scope, _ []g.Object) g.Object { return addn(-1) }
+	wait := func (_ g.Scope, _ []g.Object) g.Object {
+		mutex.Lock()
+		defer mutex.Unlock()
+		for count > 0 { cond.Wait() }
+
+		return g.NumberObject(1.0)
+	}
+
+	return g.MapObject(map[string]g.Object{
+		"add": g.BuiltinFunctionObject("add", add),
+		"done": g.BuiltinFunctionObject("done", done),
+		"wait": g.BuiltinFunctionObject("wait", wait),
+	})
+}
+
+func once(scope g.Scope, args []g.Object) g.Object {
+	var o sync.Once
+	result := g.UndefinedObject()
+
+	do := func (scope g.Scope, args []g.Object) g.Object {
+		o.Do(func () { result = g.BuiltinDo(scope, args) })
+		return result
+	}
+
+	return g.MapObject(map[string]g.Object{
+		"do": g.BuiltinFunctionObject("do", do),
+	})
+}
+
+var Exports = g.MapObject(map[string]g.Object{
+	"mutex": g.BuiltinFunctionObject("mutex", mutex),
+	"semaphore": g.BuiltinFunctionObject("semaphore", semaphore),
+	"waitGroup": g.BuiltinFunctionObject("waitGroup", waitGroup),
+	"once": g.BuiltinFunctionObject("once", once),
+})
+
+func init() {
+	g.RegisterModule("sync", Exports)
+}
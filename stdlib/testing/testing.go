@@ -0,0 +1,266 @@
+
+package testing
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	g "github.com/ajaymt/golsp/core"
+)
+
+// Result: The outcome of a test case -- its failed assertions, and its output
+// (the failures and the messages that it logged, in order)
+type Result struct {
+	Name string
+	Failures []string
+	Output []string
+	Duration time.Duration
+}
+
+// Passed: Check whether a test case passed, i.e none of its assertions failed
+func (result Result) Passed() bool {
+	return len(result.Failures) == 0
+}
+
+// Suite: The test cases that have run in an interpreter, and the setup and
+// teardown functions that are called around each of them
+type Suite struct {
+	// Filter, if it is set, selects the test cases that run by name
+	Filter *regexp.Regexp
+	// Verbose is whether test cases are reported when they start and pass, and
+	// not only when they fail
+	Verbose bool
+
+	results []Result
+	setup g.Object
+	teardown g.Object
+	mutex sync.Mutex
+}
+
+// suiteKey is the key that an interpreter keeps its suite under (see 'g.Interpreter.Value')
+type suiteKey struct{}
+
+// Attach: Create the suite that records the test cases that run in an
+// interpreter, replacing any suite that is already attached to it. The suite is
+// detached when the program ends
+// `interp`: the interpreter
+// this function returns the suite
+func Attach(interp *g.Interpreter) *Suite {
+	suite := &Suite{}
+	interp.SetValue(suiteKey{}, suite)
+
+	return suite
+}
+
+// Detach: Forget the suite of an interpreter once its results have been read
+func Detach(interp *g.Interpreter) {
+	interp.SetValue(suiteKey{}, nil)
+}
+
+// suiteOf: Find the suite of the interpreter that a scope belongs to, attaching
+// one if the program is not being run by a test runner (i.e 'golsp run a_test.golsp')
+func suiteOf(scope g.Scope) *Suite {
+	return scope.Interpreter().Value(suiteKey{}, func () interface{} { return &Suite{} }).(*Suite)
+}
+
+// Results: List the results of the test cases that have run, in the order that
+// they were run
+func (suite *Suite) Results() []Result {
+	suite.mutex.Lock()
+	defer suite.mutex.Unlock()
+	results := make([]Result, len(suite.results))
+	copy(results, suite.results)
+
+	return results
+}
+
+// Equal: Check whether two objects are deeply equal -- literals are equal if they
+// have the same type and value, lists if their elements are equal, maps and errors
+// if they have the same keys and equal values, and functions if they are the same
+// builtin or have the same name and definition
+// `a`, `b`: the objects
+// this function returns whether the objects are equal
+func Equal(a g.Object, b g.Object) bool {
+	if a.Type != b.Type { return false }
+
+	switch a.Type {
+	case g.ObjectTypeList:
+		if a.Elements.Length != b.Elements.Length { return false }
+		belements := b.Elements.ToSlice()
+		for i, element := range a.Elements.ToSlice() {
+			if !Equal(element, belements[i]) { return false }
+		}
+		return true
+
+	case g.ObjectTypeMap, g.ObjectTypeError:
+		if len(a.Map) != len(b.Map) { return false }
+		for key, value := range a.Map {
+			bvalue, exists := b.Map[key]
+			if !exists || !Equal(value, bvalue) { return false }
+		}
+		return true
+
+	case g.ObjectTypeFunction:
+		return a.Function.Name == b.Function.Name &&
+			fmt.Sprint(a.Function.FunctionPatterns, a.Function.FunctionBodies) ==
+			fmt.Sprint(b.Function.FunctionPatterns, b.Function.FunctionBodies) &&
+			(a.Function.BuiltinFunc == nil) == (b.Function.BuiltinFunc == nil)
+	}
+
+	if a.Value.Type != b.Value.Type { return false }
+	if a.Value.Type == g.STNodeTypeNumberLiteral {
+		anum, _ := g.ToNumber(a)
+		bnum, _ := g.ToNumber(b)
+		return anum == bnum
+	}
+
+	return a.Value.Head == b.Value.Head
+}
+
+// describe: Format the optional message arguments of an assertion
+func describe(failure string, message []g.Object) string {
+	if len(message) == 0 { return failure }
+
+	strs := make([]string, len(message))
+	for i, obj := range message {
+		if str, err := g.ToString(obj); err == nil {
+			strs[i] = str
+		} else {
+			strs[i] = g.Format(obj)
+		}
+	}
+
+	if failure == "" { return strings.Join(strs, " ") }
+
+	return strings.Join(strs, " ") + ": " + failure
+}
+
+// assertions: Produce the map of assertion functions that is passed to a test
+// case, i.e `[t.equal [+ 1 2] 3]`. Failed assertions are recorded in the test
+// case's result instead of stopping it, and every assertion returns whether it passed
+func assertions(result *Result, mutex *sync.Mutex) g.Object {
+	check := func (passed bool, failure string, message []g.Object) bool {
+		if passed { return true }
+		mutex.Lock()
+		defer mutex.Unlock()
+		failure = describe(failure, message)
+		result.Failures = append(result.Failures, failure)
+		result.Output = append(result.Output, failure)
+
+		return false
+	}
+
+	return g.MapObject(map[string]g.Object{
+		"equal": g.GoFunctionObject("equal", func (actual g.Object, expected g.Object, message ...g.Object) bool {
+			return check(Equal(actual, expected),
+				fmt.Sprintf("expected %v, got %v", g.Format(expected), g.Format(actual)), message)
+		}),
+		"notEqual": g.GoFunctionObject("notEqual", func (actual g.Object, unexpected g.Object, message ...g.Object) bool {
+			return check(!Equal(actual, unexpected),
+				fmt.Sprintf("expected a value other than %v", g.Format(unexpected)), message)
+		}),
+		"ok": g.GoFunctionObject("ok", func (value g.Object, message ...g.Object) bool {
+			return check(g.ToBoolean(value) && value.Type != g.ObjectTypeError,
+				fmt.Sprintf("expected a truthy value, got %v", g.Format(value)), message)
+		}),
+		"isError": g.GoFunctionObject("isError", func (value g.Object, message ...g.Object) bool {
+			return check(value.Type == g.ObjectTypeError,
+				fmt.Sprintf("expected an error, got %v", g.Format(value)), message)
+		}),
+		"fail": g.GoFunctionObject("fail", func (message ...g.Object) bool {
+			return check(false, "failed", message)
+		}),
+		"log": g.GoFunctionObject("log", func (message ...g.Object) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			result.Output = append(result.Output, describe("", message))
+		}),
+	})
+}
+
+// run: Run a test case between the suite's setup and teardown functions, and
+// report it if it fails (or if the suite is verbose)
+// `out`: where the test case is reported
+// `name`: the name of the test case
+// `fn`: the test case, a function that is called with the map of assertions
+// (see 'assertions') and the result of the setup function
+// this function returns whether the test case passed, or was not selected by the filter
+func (suite *Suite) run(out io.Writer, name string, fn g.Object) bool {
+	if suite.Filter != nil && !suite.Filter.MatchString(name) { return true }
+	if suite.Verbose { fmt.Fprintf(out, "=== RUN   %s\n", name) }
+
+	suite.mutex.Lock()
+	setup, teardown := suite.setup, suite.teardown
+	suite.mutex.Unlock()
+
+	result := Result{Name: name}
+	var mutex sync.Mutex
+	start := time.Now()
+	call := func (stage string, fn g.Object, args ...g.Object) g.Object {
+		value := g.Protect(func () g.Object { return g.CallFunction(fn, g.ListFromSlice(args)) })
+		if value.Type == g.ObjectTypeError {
+			mutex.Lock()
+			result.Failures = append(result.Failures, stage + " returned " + g.Format(value))
+			result.Output = append(result.Output, result.Failures[len(result.Failures) - 1])
+			mutex.Unlock()
+		}
+		return value
+	}
+
+	fixture := g.UndefinedObject()
+	if setup.Type == g.ObjectTypeFunction { fixture = call("setup", setup) }
+	if fixture.Type != g.ObjectTypeError {
+		call("test", fn, assertions(&result, &mutex), fixture)
+		if teardown.Type == g.ObjectTypeFunction { call("teardown", teardown, fixture) }
+	}
+	result.Duration = time.Since(start)
+
+	status := "PASS"
+	if !result.Passed() { status = "FAIL" }
+	if suite.Verbose || !result.Passed() {
+		fmt.Fprintf(out, "--- %s: %s (%.2fs)\n", status, name, result.Duration.Seconds())
+		for _, line := range result.Output {
+			fmt.Fprintf(out, "    %s\n", strings.ReplaceAll(line, "\n", "\n    "))
+		}
+	}
+
+	suite.mutex.Lock()
+	suite.results = append(suite.results, result)
+	suite.mutex.Unlock()
+
+	return result.Passed()
+}
+
+func test(scope g.Scope, name string, fn g.Object) (bool, error) {
+	if fn.Type != g.ObjectTypeFunction { return false, fmt.Errorf("test %s is not a function", name) }
+
+	return suiteOf(scope).run(scope.Interpreter().Stdout, name, fn), nil
+}
+
+func setup(scope g.Scope, fn g.Object) {
+	suite := suiteOf(scope)
+	suite.mutex.Lock()
+	defer suite.mutex.Unlock()
+	suite.setup = g.IsolateFunction(fn)
+}
+
+func teardown(scope g.Scope, fn g.Object) {
+	suite := suiteOf(scope)
+	suite.mutex.Lock()
+	defer suite.mutex.Unlock()
+	suite.teardown = g.IsolateFunction(fn)
+}
+
+var Exports = g.MapObject(map[string]g.Object{
+	"test": g.GoFunctionObject("test", test),
+	"setup": g.GoFunctionObject("setup", setup),
+	"teardown": g.GoFunctionObject("teardown", teardown),
+	"equal": g.GoFunctionObject("equal", Equal),
+})
+
+func init() {
+	g.RegisterModule("testing", Exports)
+}
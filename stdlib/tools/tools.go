@@ -0,0 +1,196 @@
+
+package tools
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	g "github.com/ajaymt/golsp/core"
+)
+
+// partial: a function and the arguments that it is partially applied to. Like
+// 'map' calls `[f... x]`, the parallel functions accept a function or a list that
+// begins with a function, i.e `{+ 1}`
+type partial struct {
+	fn g.Object
+	args []g.Object
+}
+
+// toPartial: check whether an object can be called like a function
+// this function returns the partially applied function and whether the object is one
+func toPartial(obj g.Object) (partial, bool) {
+	if obj.Type == g.ObjectTypeFunction { return partial{fn: obj}, true }
+	if obj.Type != g.ObjectTypeList || obj.Elements.Length == 0 { return partial{}, false }
+
+	elements := obj.Elements.ToSlice()
+	if elements[0].Type != g.ObjectTypeFunction { return partial{}, false }
+
+	return partial{elements[0], elements[1:]}, true
+}
+
+// isolate: give the function and every function argument its own isolated scope
+// (see 'g.IsolateFunction'), so that they can be called from another goroutine
+func (p partial) isolate() partial {
+	args := make([]g.Object, len(p.args))
+	for i, arg := range p.args {
+		args[i] = arg
+		if arg.Type == g.ObjectTypeFunction { args[i] = g.IsolateFunction(arg) }
+	}
+
+	return partial{g.IsolateFunction(p.fn), args}
+}
+
+// call: call the function with its partially applied arguments followed by `args`
+func (p partial) call(args ...g.Object) g.Object {
+	arguments := make([]g.Object, 0, len(p.args) + len(args))
+	arguments = append(append(arguments, p.args...), args...)
+
+	return g.CallFunction(p.fn, g.ListFromSlice(arguments))
+}
+
+// parallel: split a list into contiguous chunks and process each chunk in its own
+// goroutine, using at most `limit` goroutines. Every chunk is processed with its own
+// isolated copy of fn, and panics are recovered as errors. Once an element produces
+// an error, elements that come after it are skipped
+// this function returns the result of processing each element (in order) and the
+// error produced by the earliest element, if any
+func parallel(fn partial, elements []g.Object, limit int,
+	process func(partial, g.Object) g.Object) ([]g.Object, *g.Object) {
+	results := make([]g.Object, len(elements))
+	if len(elements) == 0 { return results, nil }
+	if limit > len(elements) { limit = len(elements) }
+
+	var wg sync.WaitGroup
+	failed := int64(len(elements))
+	chunksize := (len(elements) + limit - 1) / limit
+	for begin := 0; begin < len(elements); begin += chunksize {
+		end := begin + chunksize
+		if end > len(elements) { end = len(elements) }
+
+		wg.Add(1)
+		go func (fn partial, begin int, end int) {
+			defer wg.Done()
+			for i := begin; i < end; i++ {
+				if int64(i) > atomic.LoadInt64(&failed) { return }
+				results[i] = g.Protect(func () g.Object { return process(fn, elements[i]) })
+				if results[i].Type != g.ObjectTypeError { continue }
+
+				for index := atomic.LoadInt64(&failed); int64(i) < index; index = atomic.LoadInt64(&failed) {
+					if atomic.CompareAndSwapInt64(&failed, index, int64(i)) { break }
+				}
+				return
+			}
+		}(fn.isolate(), begin, end)
+	}
+	wg.Wait()
+
+	if failed < int64(len(elements)) { return results, &results[failed] }
+
+	return results, nil
+}
+
+// parallelArgs: evaluate and check the arguments of 'pmap', 'pfilter' and 'preduce',
+// i.e `[pmap f list]` or `[pmap f list limit]`
+// this function returns the function (see 'partial'), the elements of the list, the
+// concurrency limit and an error if the arguments are invalid
+func parallelArgs(name string, scope g.Scope, args []g.Object) (partial, []g.Object, int, *g.Object) {
+	arguments := g.EvalArgs(scope, args)
+	var fn partial
+	callable := false
+	if len(arguments) > 0 { fn, callable = toPartial(arguments[0]) }
+	if len(arguments) < 2 || !callable || arguments[1].Type != g.ObjectTypeList {
+		err := g.ErrorObject(name + " requires a function and a list")
+		return partial{}, nil, 0, &err
+	}
+
+	limit := runtime.NumCPU()
+	if len(arguments) > 2 {
+		limitf, err := g.ToNumber(arguments[2])
+		if err != nil || limitf < 1 {
+			err := g.ErrorObject(name + " limit must be a positive number")
+			return partial{}, nil, 0, &err
+		}
+		limit = int(limitf)
+	}
+
+	return fn, arguments[1].Elements.ToSlice(), limit, nil
+}
+
+func listObject(elements []g.Object) g.Object {
+	return g.Object{
+		Type: g.ObjectTypeList,
+		Elements: g.ListFromSlice(elements),
+	}
+}
+
+func pmap(scope g.Scope, args []g.Object) g.Object {
+	fn, elements, limit, err := parallelArgs("pmap", scope, args)
+	if err != nil { return *err }
+
+	results, err := parallel(fn, elements, limit, func (fn partial, elem g.Object) g.Object {
+		return fn.call(elem)
+	})
+	if err != nil { return *err }
+
+	return listObject(results)
+}
+
+func pfilter(scope g.Scope, args []g.Object) g.Object {
+	fn, elements, limit, err := parallelArgs("pfilter", scope, args)
+	if err != nil { return *err }
+
+	results, err := parallel(fn, elements, limit, func (fn partial, elem g.Object) g.Object {
+		return fn.call(elem)
+	})
+	if err != nil { return *err }
+
+	filtered := make([]g.Object, 0, len(elements))
+	for i, result := range results {
+		if g.ToBoolean(result) { filtered = append(filtered, elements[i]) }
+	}
+
+	return listObject(filtered)
+}
+
+// preduce reduces each chunk of the list in parallel and then reduces the
+// results of the chunks, so the function must be associative
+func preduce(scope g.Scope, args []g.Object) g.Object {
+	fn, elements, limit, err := parallelArgs("preduce", scope, args)
+	if err != nil { return *err }
+	if len(elements) == 0 { return g.UndefinedObject() }
+	if limit > len(elements) { limit = len(elements) }
+
+	chunksize := (len(elements) + limit - 1) / limit
+	chunks := make([]g.Object, 0, limit)
+	for begin := 0; begin < len(elements); begin += chunksize {
+		end := begin + chunksize
+		if end > len(elements) { end = len(elements) }
+		chunks = append(chunks, listObject(elements[begin:end]))
+	}
+
+	reduce := func (fn partial, chunk g.Object) g.Object {
+		items := chunk.Elements.ToSlice()
+		result := items[0]
+		for _, item := range items[1:] {
+			result = fn.call(result, item)
+			if result.Type == g.ObjectTypeError { break }
+		}
+
+		return result
+	}
+
+	results, err := parallel(fn, chunks, limit, reduce)
+	if err != nil { return *err }
+
+	return reduce(fn, listObject(results))
+}
+
+var Exports = g.MapObject(map[string]g.Object{
+	"pmap": g.BuiltinFunctionObject("pmap", pmap),
+	"pfilter": g.BuiltinFunctionObject("pfilter", pfilter),
+	"preduce": g.BuiltinFunctionObject("preduce", preduce),
+})
+
+func init() {
+	g.RegisterModule("tools", Exports)
+}
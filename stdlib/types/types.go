@@ -1,5 +1,5 @@
 
-package main
+package types
 
 import (
 	"strconv"
@@ -43,6 +43,12 @@ var Exports = g.MapObject(map[string]g.Object{
 		typeCheck(g.ObjectTypeList, g.STNodeTypeIdentifier)),
 	"isMap": g.BuiltinFunctionObject("isMap",
 		typeCheck(g.ObjectTypeMap, g.STNodeTypeIdentifier)),
+	"isError": g.BuiltinFunctionObject("isError",
+		typeCheck(g.ObjectTypeError, g.STNodeTypeIdentifier)),
 
 	"parseNumber": g.BuiltinFunctionObject("parseNumber", parseNumber),
 })
+
+func init() {
+	g.RegisterModule("types", Exports)
+}
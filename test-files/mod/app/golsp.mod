@@ -0,0 +1,6 @@
+# a directory dependency and an archive dependency, which are both copied into
+# golsp_modules by 'golsp mod vendor' (see install.args)
+module app
+version 1.0.0
+require greet ../greet
+require util ../util-1.2.0.tgz 1.2.0
@@ -0,0 +1,2 @@
+module greet
+version 0.1.0
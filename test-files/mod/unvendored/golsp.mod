@@ -0,0 +1,3 @@
+module unvendored
+require greet ../greet
+require util ../util-1.2.0.tgz
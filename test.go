@@ -0,0 +1,186 @@
+
+// Test runner
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+	golsp "github.com/ajaymt/golsp/core"
+	"github.com/ajaymt/golsp/stdlib/testing"
+)
+
+// TEST_SUFFIX is the suffix of the files that 'golsp test' runs
+const TEST_SUFFIX = "_test.golsp"
+
+// testFile: the outcome of running a test file
+type testFile struct {
+	filename string
+	output bytes.Buffer
+	results []testing.Result
+	// err is why the file failed other than a failed test case, i.e a syntax error
+	err string
+	duration time.Duration
+}
+
+func (file *testFile) failed() int {
+	failed := 0
+	for _, result := range file.results {
+		if !result.Passed() { failed++ }
+	}
+
+	return failed
+}
+
+// exitStatus: the value that an interpreter's ExitFunc panics with, so that
+// 'os.exit' in a test file ends that file instead of the test runner
+type exitStatus int
+
+// runTestFile: run a test file with its own interpreter, collecting its output
+// and the results of its test cases
+func runTestFile(opts *options, filter *regexp.Regexp, verbose bool, file *testFile) {
+	start := time.Now()
+	defer func () { file.duration = time.Since(start) }()
+
+	dirname, filename, program, err := readProgram(file.filename)
+	if err != nil {
+		file.err = err.Error()
+		return
+	}
+	if err := golsp.CheckSyntax(golsp.Tokenize(program)); err != nil {
+		file.err = err.Error()
+		return
+	}
+
+	interp := opts.interpreter()
+	interp.Stdout = &lockedWriter{writer: &file.output}
+	interp.Stderr = interp.Stdout
+	// 'os.exit' in a 'go' block or timer panics inside of 'Protect', which
+	// recovers, so the first status is recorded before panicking
+	var exitMutex sync.Mutex
+	exited := false
+	interp.ExitFunc = func (code int) {
+		exitMutex.Lock()
+		if !exited && code != 0 { file.err = fmt.Sprintf("exited with status %d", code) }
+		exited = true
+		exitMutex.Unlock()
+		panic(exitStatus(code))
+	}
+	suite := testing.Attach(interp)
+	defer testing.Detach(interp)
+	suite.Filter = filter
+	suite.Verbose = verbose
+
+	defer func () {
+		file.results = suite.Results()
+		if r := recover(); r != nil {
+			if _, isExit := r.(exitStatus); !isExit { panic(r) }
+		}
+	}()
+
+	result := interp.Run(dirname, filename, []string{}, program)
+	exitMutex.Lock()
+	defer exitMutex.Unlock()
+	if result.Type == golsp.ObjectTypeError && !exited { file.err = golsp.Format(result) }
+}
+
+// lockedWriter: a writer that can be shared by a test file's 'go' blocks
+type lockedWriter struct {
+	writer *bytes.Buffer
+	mutex sync.Mutex
+}
+
+func (w *lockedWriter) Write(data []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.writer.Write(data)
+}
+
+func testCommand(args []string) int {
+	flags := newFlagSet("test")
+	opts := options{}
+	opts.register(flags)
+	verbose := flags.Bool("v", false, "report every test case, and the output of passing files")
+	run := flags.String("run", "", "only run the test cases whose names match `regexp`")
+	parallel := flags.Int("parallel", 1, "run up to `n` test files at the same time")
+	flags.Parse(args)
+
+	var filter *regexp.Regexp
+	if *run != "" {
+		var err error
+		if filter, err = regexp.Compile(*run); err != nil { return fail("-run: %v", err) }
+	}
+	if *parallel < 1 { *parallel = 1 }
+
+	// the timeout applies to the whole run instead of each file's interpreter
+	if opts.timeout > 0 {
+		timeout := opts.timeout
+		opts.timeout = 0
+		time.AfterFunc(timeout, func () {
+			fmt.Fprintf(os.Stderr, "golsp test: timed out after %v\n", timeout)
+			os.Exit(EXIT_TIMEOUT)
+		})
+	}
+
+	paths := flags.Args()
+	if len(paths) == 0 { paths = []string{"."} }
+	filenames, err := findFiles(paths, TEST_SUFFIX)
+	if err != nil { return fail("%v", err) }
+	if len(filenames) == 0 {
+		fmt.Println("no test files")
+		return EXIT_OK
+	}
+
+	files := make([]*testFile, len(filenames))
+	done := make([]chan struct{}, len(filenames))
+	pending := make(chan int)
+	for i, filename := range filenames {
+		files[i] = &testFile{filename: filename}
+		done[i] = make(chan struct{})
+	}
+	for worker := 0; worker < *parallel; worker++ {
+		go func () {
+			for i := range pending {
+				runTestFile(&opts, filter, *verbose, files[i])
+				close(done[i])
+			}
+		}()
+	}
+	go func () {
+		for i := range files { pending <- i }
+		close(pending)
+	}()
+
+	// files are reported in order, as soon as each of them is done
+	tests, failedtests, failedfiles := 0, 0, 0
+	for i, file := range files {
+		<-done[i]
+		tests += len(file.results)
+		failedtests += file.failed()
+
+		if file.err == "" && file.failed() == 0 {
+			if *verbose { os.Stdout.Write(file.output.Bytes()) }
+			fmt.Printf("ok    %s\t%d tests (%.2fs)\n", file.filename, len(file.results), file.duration.Seconds())
+			continue
+		}
+
+		failedfiles++
+		os.Stdout.Write(file.output.Bytes())
+		if file.err != "" { fmt.Printf("%s: %s\n", file.filename, file.err) }
+		fmt.Printf("FAIL  %s\t%d of %d tests failed (%.2fs)\n",
+			file.filename, file.failed(), len(file.results), file.duration.Seconds())
+	}
+
+	if failedfiles > 0 {
+		fmt.Printf("FAIL: %d of %d tests failed, %d of %d files failed\n",
+			failedtests, tests, failedfiles, len(files))
+		return EXIT_FAILURE
+	}
+	fmt.Printf("PASS: %d tests in %d files\n", tests, len(files))
+
+	return EXIT_OK
+}